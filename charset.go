@@ -0,0 +1,27 @@
+package crawler
+
+import (
+	"bytes"
+	"fmt"
+
+	"golang.org/x/net/html/charset"
+)
+
+// decodeToUTF8 检测body的编码并转换为UTF-8, 依次使用指定编码、BOM、Content-Type、meta charset, 最后通过内容嗅探
+func decodeToUTF8(body []byte, contentType string, override string) ([]byte, string, error) {
+	e, name := charset.Lookup(override)
+	if override != "" && e == nil {
+		return body, "", fmt.Errorf("unknown encoding: %s", override)
+	}
+	if e == nil {
+		e, name, _ = charset.DetermineEncoding(body, contentType)
+	}
+	decoded := body
+	if name != "utf-8" {
+		var err error
+		if decoded, err = e.NewDecoder().Bytes(body); err != nil {
+			return body, name, err
+		}
+	}
+	return bytes.TrimPrefix(decoded, []byte("\xef\xbb\xbf")), name, nil
+}
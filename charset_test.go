@@ -0,0 +1,35 @@
+package crawler
+
+import (
+	"testing"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func TestDecodeToUTF8(t *testing.T) {
+	gbk, _ := simplifiedchinese.GBK.NewEncoder().Bytes([]byte("<html><head><meta charset=\"gbk\"></head><body>你好</body></html>"))
+
+	cases := []struct {
+		contentType string
+		override    string
+		encoding    string
+	}{
+		{"text/html; charset=gbk", "", "gbk"},
+		{"text/html", "", "gbk"},
+		{"text/html; charset=utf-8", "gb18030", "gb18030"},
+	}
+	for _, c := range cases {
+		body, encoding, err := decodeToUTF8(gbk, c.contentType, c.override)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encoding != c.encoding || string(body) != "<html><head><meta charset=\"gbk\"></head><body>你好</body></html>" {
+			t.Errorf("decodeToUTF8(%q, %q) = %q, %q", c.contentType, c.override, body, encoding)
+		}
+	}
+
+	body, encoding, _ := decodeToUTF8([]byte("\xef\xbb\xbfhello"), "text/plain; charset=gbk", "")
+	if encoding != "utf-8" || string(body) != "hello" {
+		t.Errorf("BOM not detected: %q, %q", body, encoding)
+	}
+}
@@ -0,0 +1,80 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// ContentType 响应内容类型
+type ContentType int
+
+// 响应内容类型
+const (
+	BinaryContent ContentType = iota
+	HTMLContent
+	XMLContent
+	JSONContent
+	TextContent
+)
+
+func (t ContentType) String() string {
+	switch t {
+	case HTMLContent:
+		return "html"
+	case XMLContent:
+		return "xml"
+	case JSONContent:
+		return "json"
+	case TextContent:
+		return "text"
+	}
+	return "binary"
+}
+
+// IsText 是否为文本类内容
+func (t ContentType) IsText() bool {
+	return t != BinaryContent
+}
+
+// detectContentType 根据Content-Type判断内容类型, 缺失或无法识别时根据内容嗅探
+func detectContentType(contentType string, body []byte) ContentType {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+	if mediaType == "" || mediaType == "application/octet-stream" {
+		if looksLikeJSON(body) {
+			return JSONContent
+		}
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+	}
+
+	switch {
+	case strings.HasSuffix(mediaType, "json"):
+		return JSONContent
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		// 不少接口以text/html返回JSON
+		if looksLikeJSON(body) {
+			return JSONContent
+		}
+		return HTMLContent
+	case strings.HasSuffix(mediaType, "xml"):
+		return XMLContent
+	case mediaType == "text/plain":
+		if looksLikeJSON(body) {
+			return JSONContent
+		}
+		return TextContent
+	case strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "javascript"):
+		return TextContent
+	}
+	return BinaryContent
+}
+
+func looksLikeJSON(body []byte) bool {
+	body = bytes.TrimSpace(body)
+	return len(body) > 0 && (body[0] == '{' || body[0] == '[') && json.Valid(body)
+}
@@ -34,14 +34,14 @@ func (ctx *Context) AddRequest(req *Request) {
 	}
 	context.LastRequest = req
 	req.context = context
-	ctx.Engine.RequestQueue <- req
+	ctx.Engine.enqueueRequest(req)
 }
 
 // AddItem 处理item
 func (ctx *Context) AddItem(item interface{}) {
 	// i :=1;
 	// fmt.Println(item)
-	ctx.Engine.ItemQueue <- &itemWrapper{item: item, context: ctx}
+	ctx.Engine.enqueueItem(&itemWrapper{item: item, context: ctx})
 }
 
 // Emit 提交Request或item
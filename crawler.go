@@ -1,7 +1,12 @@
 package crawler
 
 import (
+	"fmt"
+	"io"
+	"log"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,12 +25,18 @@ type Crawler struct {
 	onStart              func(ctx *Context)
 	Pipelines            []ItemPipeline
 	ItemTypeFuncs        map[string]ItemPipelineFunc
+	itemHandlers         map[reflect.Type]ItemProcessFunc
 	responseCallback     func(res *Response, ctx *Context)
 	requestErrorCallback RequestErrorCallback
 	redirectCallback     RedirectCallback
+	unchangedCallback    ResponseCallback
+	itemDroppedCallback  ItemErrorCallback
+	itemErrorCallback    ItemErrorCallback
 	Settings             *Settings
 	Engine               *CrawlEngine
 	context              *Context
+	stopOnce             sync.Once
+	err                  error
 }
 
 // NewCrawler 创建一个爬虫
@@ -72,7 +83,7 @@ func (c *Crawler) startRequests(ctx *Context) []*Request {
 	return []*Request{}
 }
 
-// Wait 等待引擎进入空闲状态
+// Wait 等待引擎进入空闲状态, 不关闭pipeline和导出文件, 之后仍可继续添加请求并再次Wait; 结束爬取需调用Stop
 func (c *Crawler) Wait() {
 	c.Engine.Wait()
 }
@@ -86,7 +97,8 @@ func (c *Crawler) IsIdle() bool {
 	return c.Engine.IsIdle()
 }
 
-// Start 启动爬虫
+// Start 启动爬虫, pipeline的OpenSpider失败时不启动, 原因可通过Err获取;
+// wait为true时等待爬取完成后调用Stop; wait为false时立即返回, 需调用Stop结束爬取, 否则pipeline和导出文件不会关闭
 func (c *Crawler) Start(wait bool) *Crawler {
 
 	worker := func() {
@@ -94,23 +106,101 @@ func (c *Crawler) Start(wait bool) *Crawler {
 			c.context.Emit(req)
 		}
 	}
+	if err := c.openPipelines(); err != nil {
+		c.err = err
+		log.Println(err)
+		// 已打开的pipeline已在openPipelines中关闭, 之后的Stop不再执行关闭逻辑
+		c.stopOnce.Do(func() {})
+		return c
+	}
 	c.Engine.Start()
+	if c.Engine.feedExporter != nil {
+		c.Engine.feedExporter.Name = c.Name
+		c.Engine.feedExporter.StartTime = time.Now()
+	}
+	if c.onStart != nil {
+		c.onStart(c.context)
+	}
 	if wait {
 		worker()
-		c.Wait()
+		c.Stop()
 	} else {
-		go worker()
+		// 起始请求入队前引擎不应被判断为空闲
+		atomic.AddInt32(&c.Engine.pending, 1)
+		go func() {
+			defer c.Engine.done()
+			worker()
+		}()
 	}
 	return c
 }
 
-// OnStart 设置start回调
+// Err 启动失败的原因
+func (c *Crawler) Err() error {
+	return c.err
+}
+
+// openPipelines 按顺序调用pipeline的OpenSpider, 失败时关闭已打开的pipeline
+func (c *Crawler) openPipelines() error {
+	for i, pipeline := range c.Pipelines {
+		if opener, ok := pipeline.(SpiderOpener); ok {
+			if err := opener.OpenSpider(c.context); err != nil {
+				c.closePipelines(c.Pipelines[:i])
+				return fmt.Errorf("open pipeline %T failed: %w", pipeline, err)
+			}
+		}
+	}
+	return nil
+}
+
+// closePipelines 按顺序调用pipeline的CloseSpider, 未实现时调用io.Closer的Close
+func (c *Crawler) closePipelines(pipelines []ItemPipeline) {
+	for _, pipeline := range pipelines {
+		var err error
+		if closer, ok := pipeline.(SpiderCloser); ok {
+			err = closer.CloseSpider(c.context)
+		} else if closer, ok := pipeline.(io.Closer); ok {
+			err = closer.Close()
+		}
+		if err != nil {
+			log.Printf("close pipeline %T failed: %v", pipeline, err)
+		}
+	}
+}
+
+// Stop 等待引擎进入空闲状态后结束爬取: 关闭pipeline和导出文件并调用stop回调, 多次调用只执行一次
+func (c *Crawler) Stop() {
+	c.stopOnce.Do(func() {
+		c.Engine.Wait()
+		c.closePipelines(c.Pipelines)
+		if c.Engine.feedExporter != nil {
+			if err := c.Engine.feedExporter.Close(); err != nil {
+				log.Println(err)
+			}
+		}
+		if closer, ok := c.Engine.incrementalStore.(io.Closer); ok && c.Engine.ownsIncrementalStore {
+			if err := closer.Close(); err != nil {
+				log.Printf("close incremental store failed: %v", err)
+			}
+		}
+		if c.onStop != nil {
+			c.onStop(c.context)
+		}
+	})
+}
+
+// Stats 爬取统计
+func (c *Crawler) Stats() *Stats {
+	return c.Engine.Stats
+}
+
+// OnStart 设置start回调, 在引擎启动后、发出起始请求前调用
 func (c *Crawler) OnStart(callback func(ctx *Context)) *Crawler {
 	c.onStart = callback
 	return c
 }
 
-// OnStop 设置stop回调
+// OnStop 设置stop回调, 在pipeline和导出文件关闭后调用
 func (c *Crawler) OnStop(callback func(ctx *Context)) *Crawler {
 	c.onStop = callback
 	return c
@@ -139,6 +229,21 @@ func (c *Crawler) withSettings(s *Settings) *Crawler {
 	if s.Transport != nil {
 		c.Settings.Transport = s.Transport
 	}
+	if s.Incremental {
+		c.Settings.Incremental = true
+	}
+	if s.IncrementalStorePath != "" {
+		c.Settings.IncrementalStorePath = s.IncrementalStorePath
+	}
+	if s.MaxResponseSize != 0 {
+		c.Settings.MaxResponseSize = s.MaxResponseSize
+	}
+	if s.WarnResponseSize != 0 {
+		c.Settings.WarnResponseSize = s.WarnResponseSize
+	}
+	if s.Feeds != nil {
+		c.Settings.Feeds = s.Feeds
+	}
 	return c
 }
 
@@ -160,6 +265,24 @@ func (c *Crawler) ClearPipelines() *Crawler {
 	return c
 }
 
+// AddItemProcessFunc 添加可以返回错误的Pipeline func, 返回DropItem时丢弃item
+func (c *Crawler) AddItemProcessFunc(f ItemProcessFunc) *Crawler {
+	c.Pipelines = append(c.Pipelines, ProcessFuncPipeline(f))
+	return c
+}
+
+// OnItemDropped item被pipeline丢弃时的回调, err为*DroppedItemError
+func (c *Crawler) OnItemDropped(callback ItemErrorCallback) *Crawler {
+	c.itemDroppedCallback = callback
+	return c
+}
+
+// OnItemError pipeline处理item失败时的回调, 未设置时输出日志
+func (c *Crawler) OnItemError(callback ItemErrorCallback) *Crawler {
+	c.itemErrorCallback = callback
+	return c
+}
+
 // OnItem 默认item处理函数
 func (c *Crawler) OnItem(f ItemPipelineFunc) *Crawler {
 	// ta :=
@@ -167,7 +290,7 @@ func (c *Crawler) OnItem(f ItemPipelineFunc) *Crawler {
 	return c
 }
 
-// OnItemType 与itemExample同类型的item处理函数
+// OnItemType 与itemExample同类型的item处理函数, 按类型名匹配; 新代码建议使用HandleItem
 func (c *Crawler) OnItemType(itemExample interface{}, f ItemPipelineFunc) *Crawler {
 	c.ItemTypeFuncs[reflect.TypeOf(itemExample).String()] = f
 	return c
@@ -203,6 +326,31 @@ func (c *Crawler) OnRedirect(callback RedirectCallback) *Crawler {
 	return c
 }
 
+// OnUnchanged Set callback for pages unchanged since last crawl, unchanged pages are skipped if not set
+func (c *Crawler) OnUnchanged(callback ResponseCallback) *Crawler {
+	c.unchangedCallback = callback
+	return c
+}
+
+// WithIncrementalStore 开启增量爬取并使用自定义的页面状态存储, 存储由调用者关闭, 可在多次爬取之间共用
+func (c *Crawler) WithIncrementalStore(store IncrementalStore) *Crawler {
+	c.Settings.Incremental = true
+	c.Engine.incrementalStore = store
+	c.Engine.ownsIncrementalStore = false
+	return c
+}
+
+// AddFeed 添加导出目标, 与Settings.Feeds相同
+func (c *Crawler) AddFeed(uri string, options FeedOptions) *Crawler {
+	if c.Engine.feedExporter == nil {
+		c.Engine.feedExporter = &FeedExporter{Stats: c.Engine.Stats}
+	}
+	if err := c.Engine.feedExporter.AddFeed(uri, options); err != nil {
+		log.Println(err)
+	}
+	return c
+}
+
 // CrawlURL crawl one url
 func (c *Crawler) CrawlURL(url string) {
 	c.context.AddRequest(GetURL(url))
@@ -0,0 +1,115 @@
+package crawler
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ContentDecoder Content-Encoding解压函数
+type ContentDecoder func(r io.Reader) (io.ReadCloser, error)
+
+var (
+	contentDecoders      = map[string]ContentDecoder{}
+	contentEncodings     []string
+	contentDecodersMutex sync.RWMutex
+)
+
+func init() {
+	RegisterContentDecoder("gzip", func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	})
+	RegisterContentDecoder("deflate", decodeDeflate)
+	RegisterContentDecoder("br", func(r io.Reader) (io.ReadCloser, error) {
+		return ioutil.NopCloser(brotli.NewReader(r)), nil
+	})
+	RegisterContentDecoder("zstd", func(r io.Reader) (io.ReadCloser, error) {
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	})
+}
+
+// RegisterContentDecoder 注册Content-Encoding解压器, 已注册的编码会出现在Accept-Encoding请求头中
+func RegisterContentDecoder(encoding string, decoder ContentDecoder) {
+	encoding = strings.ToLower(encoding)
+	contentDecodersMutex.Lock()
+	defer contentDecodersMutex.Unlock()
+	if _, ok := contentDecoders[encoding]; !ok {
+		contentEncodings = append(contentEncodings, encoding)
+	}
+	contentDecoders[encoding] = decoder
+}
+
+// acceptEncoding 所有已注册的编码, 用作Accept-Encoding请求头
+func acceptEncoding() string {
+	contentDecodersMutex.RLock()
+	defer contentDecodersMutex.RUnlock()
+	return strings.Join(contentEncodings, ", ")
+}
+
+// decodeDeflate HTTP的deflate应为zlib格式, 但部分服务器发送裸deflate数据
+func decodeDeflate(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(header) == 2 && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+type multiReadCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m *multiReadCloser) Close() error {
+	var err error
+	for i := len(m.closers) - 1; i >= 0; i-- {
+		if e := m.closers[i].Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// decodeContent 按Content-Encoding逆序逐层解压
+func decodeContent(body io.Reader, contentEncoding string) (io.ReadCloser, error) {
+	result := &multiReadCloser{Reader: body}
+	encodings := strings.Split(contentEncoding, ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		encoding := strings.ToLower(strings.TrimSpace(encodings[i]))
+		if encoding == "" || encoding == "identity" {
+			continue
+		}
+		contentDecodersMutex.RLock()
+		decoder := contentDecoders[encoding]
+		contentDecodersMutex.RUnlock()
+		if decoder == nil {
+			result.Close()
+			return nil, fmt.Errorf("unsupported content encoding: %s", encoding)
+		}
+		reader, err := decoder(result.Reader)
+		if err != nil {
+			result.Close()
+			return nil, fmt.Errorf("decode content encoding %s failed: %w", encoding, err)
+		}
+		result.Reader = reader
+		result.closers = append(result.closers, reader)
+	}
+	return result, nil
+}
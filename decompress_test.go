@@ -0,0 +1,110 @@
+package crawler
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func compress(t *testing.T, data []byte, encoding string) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	case "zstd":
+		w, _ = zstd.NewWriter(&buf)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeContent(t *testing.T) {
+	content := []byte("<html><body>hello world</body></html>")
+	cases := []struct {
+		header string
+		body   []byte
+	}{
+		{"gzip", compress(t, content, "gzip")},
+		{"deflate", compress(t, content, "deflate")},
+		{"deflate", compress(t, content, "raw-deflate")},
+		{"br", compress(t, content, "br")},
+		{"zstd", compress(t, content, "zstd")},
+		{"gzip, br", compress(t, compress(t, content, "gzip"), "br")},
+		{"identity", content},
+	}
+	for _, c := range cases {
+		reader, err := decodeContent(bytes.NewReader(c.body), c.header)
+		if err != nil {
+			t.Fatalf("%s: %v", c.header, err)
+		}
+		result, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil || !bytes.Equal(result, content) {
+			t.Errorf("%s: got %q, %v", c.header, result, err)
+		}
+	}
+
+	if _, err := decodeContent(bytes.NewReader(content), "compress"); err == nil {
+		t.Error("expected error for unsupported encoding")
+	}
+	if _, err := decodeContent(bytes.NewReader(content), "gzip"); err == nil {
+		t.Error("expected error for corrupt gzip body")
+	}
+}
+
+func TestReadResponse(t *testing.T) {
+	request, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	res := &http.Response{
+		StatusCode:    200,
+		ContentLength: -1,
+		Header:        http.Header{"Content-Encoding": {"gzip"}},
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte("not gzip"))),
+		Request:       request,
+	}
+	if _, err := ReadResponse(res); err == nil {
+		t.Error("expected error for corrupt gzip body")
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader([]byte("not gzip")))
+	if response := NewResponse(res); response == nil || response.StatusCode != 200 || len(response.Body) != 0 {
+		t.Errorf("unexpected response %+v", response)
+	}
+}
+
+func TestToHTTPRequestHeaders(t *testing.T) {
+	req := NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.Headers = nil
+	request, err := req.toHTTPRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Header.Get("Accept-Encoding") == "" {
+		t.Error("Accept-Encoding not set")
+	}
+
+	req.Headers = http.Header{"X-Test": {"1"}}
+	if request, err = req.toHTTPRequest(); err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("If-None-Match", "etag")
+	if len(req.Headers) != 1 {
+		t.Errorf("request headers modified: %v", req.Headers)
+	}
+}
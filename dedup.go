@@ -0,0 +1,207 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// DedupStore 已处理过的item指纹存储
+type DedupStore interface {
+	// Contains 指纹是否已记录
+	Contains(fingerprint string) (bool, error)
+	// Add 记录指纹, 指纹已存在时返回false
+	Add(fingerprint string) (bool, error)
+}
+
+type memoryDedupStore struct {
+	fingerprints sync.Map
+}
+
+// NewMemoryDedupStore 创建内存中的指纹存储, 仅在本次运行内有效
+func NewMemoryDedupStore() DedupStore {
+	return &memoryDedupStore{}
+}
+
+func (s *memoryDedupStore) Contains(fingerprint string) (bool, error) {
+	_, ok := s.fingerprints.Load(fingerprint)
+	return ok, nil
+}
+
+func (s *memoryDedupStore) Add(fingerprint string) (bool, error) {
+	_, loaded := s.fingerprints.LoadOrStore(fingerprint, true)
+	return !loaded, nil
+}
+
+type fileDedupStore struct {
+	memoryDedupStore
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// NewFileDedupStore 创建基于文件的指纹存储, 每行一个指纹, 多次运行之间保持状态
+func NewFileDedupStore(path string) (DedupStore, error) {
+	store := &fileDedupStore{}
+	// 上次运行中断时最后一行可能没有换行符, 需先补上, 以免与下一个指纹写在同一行
+	var partialLine bool
+	if file, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			// 写坏的行不会与完整的指纹相同, 无需处理
+			if fingerprint := strings.TrimSpace(scanner.Text()); fingerprint != "" {
+				store.fingerprints.Store(fingerprint, true)
+			}
+		}
+		err = scanner.Err()
+		if info, statErr := file.Stat(); err == nil && statErr == nil && info.Size() > 0 {
+			last := make([]byte, 1)
+			if _, err = file.ReadAt(last, info.Size()-1); err == nil {
+				partialLine = last[0] != '\n'
+			}
+		}
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if partialLine {
+		if _, err := file.WriteString("\n"); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	store.file = file
+	return store, nil
+}
+
+func (s *fileDedupStore) Add(fingerprint string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, loaded := s.fingerprints.LoadOrStore(fingerprint, true); loaded {
+		return false, nil
+	}
+	if _, err := s.file.WriteString(fingerprint + "\n"); err != nil {
+		s.fingerprints.Delete(fingerprint)
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *fileDedupStore) Close() error {
+	return s.file.Close()
+}
+
+// DedupPipeline 丢弃重复的item, 设置Fields时按这些字段的值判断, 否则按item的完整内容判断;
+// 字段名与导出时相同, 所有Fields都为空的item不做判断. 重复的item计入dedup/duplicate_count
+//
+// 由引擎调用时指纹在item通过所有pipeline并导出后才写入Store, 被之后的pipeline丢弃的item下次仍会处理;
+// 处理中的item的重复项同样丢弃. 写入失败时计入dedup/save_failed_count
+type DedupPipeline struct {
+	Fields []string
+	// Store 指纹存储, 为空时使用内存存储; 由调用者负责关闭
+	Store DedupStore
+	mutex sync.Mutex
+	// pending 已通过去重但还未确定结果的item指纹
+	pending map[string]bool
+}
+
+// NewDedupPipeline 创建按fields去重的DedupPipeline, fields为空时按完整内容去重
+func NewDedupPipeline(fields ...string) *DedupPipeline {
+	return &DedupPipeline{Fields: fields}
+}
+
+// Fingerprint item的指纹, 没有任何key字段时返回""
+func (p *DedupPipeline) Fingerprint(item interface{}) (string, error) {
+	if len(p.Fields) == 0 {
+		data, err := marshalItem(item)
+		if err != nil {
+			return "", err
+		}
+		return contentHash(data), nil
+	}
+	fields, err := itemFields(item)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	empty := true
+	for _, f := range selectFields(fields, p.Fields) {
+		if value := string(f.Value); value != "null" && value != `""` {
+			empty = false
+		}
+		buf.WriteString(f.Name)
+		buf.WriteByte('=')
+		buf.Write(f.Value)
+		buf.WriteByte('\n')
+	}
+	if empty {
+		return "", nil
+	}
+	return contentHash(buf.Bytes()), nil
+}
+
+// ProcessItem 实现ItemPipeline接口
+func (p *DedupPipeline) ProcessItem(item interface{}, ctx *Context) interface{} {
+	return processItemE(p, item, ctx)
+}
+
+// ProcessItemE 实现ItemPipelineE接口, 通过去重的item立即记录指纹
+func (p *DedupPipeline) ProcessItemE(item interface{}, ctx *Context) (interface{}, error) {
+	item, commit, err := p.ProcessItemCommit(item, ctx)
+	if commit != nil {
+		commit(true)
+	}
+	return item, err
+}
+
+// ProcessItemCommit 实现ItemCommitter接口, item通过所有pipeline并导出后才记录指纹
+func (p *DedupPipeline) ProcessItemCommit(item interface{}, ctx *Context) (interface{}, func(passed bool), error) {
+	fingerprint, err := p.Fingerprint(item)
+	if err != nil || fingerprint == "" {
+		return item, nil, err
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.Store == nil {
+		p.Store = NewMemoryDedupStore()
+	}
+	duplicate := p.pending[fingerprint]
+	if !duplicate {
+		if duplicate, err = p.Store.Contains(fingerprint); err != nil {
+			return nil, nil, err
+		}
+	}
+	if duplicate {
+		if ctx != nil && ctx.Engine != nil {
+			ctx.Engine.Stats.Inc("dedup/duplicate_count")
+		}
+		return nil, nil, DropItem("duplicate")
+	}
+	if p.pending == nil {
+		p.pending = map[string]bool{}
+	}
+	p.pending[fingerprint] = true
+	return item, func(passed bool) {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		delete(p.pending, fingerprint)
+		if !passed {
+			return
+		}
+		if _, err := p.Store.Add(fingerprint); err != nil {
+			log.Printf("save dedup fingerprint failed: %v", err)
+			if ctx != nil && ctx.Engine != nil {
+				ctx.Engine.Stats.Inc("dedup/save_failed_count")
+			}
+		}
+	}, nil
+}
@@ -0,0 +1,140 @@
+package crawler
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type dedupProduct struct {
+	SKU   string `crawler:"sku"`
+	Title string `crawler:"title"`
+}
+
+func TestDedupPipeline(t *testing.T) {
+	stats := NewStats()
+	ctx := &Context{Engine: &CrawlEngine{Stats: stats}}
+
+	pipeline := NewDedupPipeline("sku")
+	items := []interface{}{
+		&dedupProduct{SKU: "a", Title: "A"},
+		dedupProduct{SKU: "a", Title: "A from another listing"},
+		map[string]interface{}{"sku": "a"},
+		&dedupProduct{SKU: "b"},
+		&dedupProduct{Title: "no key"},
+		&dedupProduct{Title: "no key"},
+	}
+	var kept int
+	for _, item := range items {
+		result, err := pipeline.ProcessItemE(item, ctx)
+		if err == nil && result != nil {
+			kept++
+		} else if !IsDropItem(err) {
+			t.Errorf("ProcessItem(%v) = %v", item, err)
+		}
+	}
+	if kept != 4 || stats.Get("dedup/duplicate_count") != 2 {
+		t.Errorf("kept = %d, stats = %v", kept, stats.Values())
+	}
+
+	content := &DedupPipeline{}
+	content.ProcessItemE(map[string]interface{}{"a": 1, "b": 2}, ctx)
+	if _, err := content.ProcessItemE(map[string]interface{}{"b": 2, "a": 1}, ctx); !IsDropItem(err) {
+		t.Error("items with the same content should be dropped")
+	}
+	if _, err := content.ProcessItemE(map[string]interface{}{"a": 1, "b": 3}, ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDedupPipelineCommit(t *testing.T) {
+	pipeline := NewDedupPipeline("sku")
+	_, commit, err := pipeline.ProcessItemCommit(&dedupProduct{SKU: "a"}, nil)
+	if err != nil || commit == nil {
+		t.Fatalf("commit is nil: %t, err = %v", commit == nil, err)
+	}
+	if _, _, err := pipeline.ProcessItemCommit(&dedupProduct{SKU: "a"}, nil); !IsDropItem(err) {
+		t.Error("duplicate of an item in progress should be dropped")
+	}
+	commit(false)
+	fingerprint, _ := pipeline.Fingerprint(&dedupProduct{SKU: "a"})
+	if seen, _ := pipeline.Store.Contains(fingerprint); seen {
+		t.Error("fingerprint of a failed item should not be recorded")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	var mutex sync.Mutex
+	var exported []string
+	dropFirst := true
+	NewCrawler(&Settings{MaxConcurrentProcessItems: 1}).
+		ClearPipelines().
+		AddItemPipeline(pipeline).
+		AddItemProcessFunc(func(item interface{}, ctx *Context) (interface{}, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if dropFirst {
+				dropFirst = false
+				return nil, DropItem("not ready")
+			}
+			exported = append(exported, item.(*dedupProduct).SKU)
+			return item, nil
+		}).
+		OnResponse(func(res *Response, ctx *Context) {
+			for i := 0; i < 3; i++ {
+				ctx.Emit(&dedupProduct{SKU: "b"})
+			}
+		}).
+		WithStartRequests(func(ctx *Context) []*Request {
+			return []*Request{GetURL(server.URL)}
+		}).
+		Start(true)
+	mutex.Lock()
+	defer mutex.Unlock()
+	if fmt.Sprint(exported) != "[b]" {
+		t.Errorf("exported = %v", exported)
+	}
+}
+
+func TestFileDedupStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen.txt")
+	for run, want := range []bool{true, false} {
+		store, err := NewFileDedupStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pipeline := &DedupPipeline{Fields: []string{"sku"}, Store: store}
+		_, err = pipeline.ProcessItemE(&dedupProduct{SKU: "a"}, nil)
+		if (err == nil) != want {
+			t.Errorf("run %d: err = %v", run, err)
+		}
+		if _, err := pipeline.ProcessItemE(&dedupProduct{SKU: "a"}, nil); !IsDropItem(err) {
+			t.Errorf("run %d: duplicate was not dropped: %v", run, err)
+		}
+		if err := store.(io.Closer).Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(path, []byte("a\nbro"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileDedupStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Add("b")
+	store.(io.Closer).Close()
+	if data, _ := ioutil.ReadFile(path); string(data) != "a\nbro\nb\n" {
+		t.Errorf("partial line was not terminated: %q", data)
+	}
+	if _, err := NewFileDedupStore(t.TempDir()); err == nil {
+		t.Error("opening a directory should fail")
+	}
+}
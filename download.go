@@ -0,0 +1,124 @@
+package crawler
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+)
+
+// StreamCallback 流式下载回调, body为解压后的响应内容, 回调返回后body即被关闭
+type StreamCallback func(res *Response, body io.Reader, ctx *Context)
+
+// ResponseTooLargeError 响应大小超过MaxResponseSize
+type ResponseTooLargeError struct {
+	URL     string
+	Size    int64
+	MaxSize int64
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("response size of %s exceeds max response size %d: %d", e.URL, e.MaxSize, e.Size)
+}
+
+// sizeLimitReader 超过maxSize时返回ResponseTooLargeError, 超过warnSize时输出警告
+type sizeLimitReader struct {
+	reader   io.Reader
+	url      string
+	maxSize  int64
+	warnSize int64
+	size     int64
+	warned   bool
+}
+
+func (r *sizeLimitReader) Read(p []byte) (int, error) {
+	if r.maxSize > 0 && int64(len(p)) > r.maxSize-r.size+1 {
+		p = p[:r.maxSize-r.size+1]
+	}
+	n, err := r.reader.Read(p)
+	r.size += int64(n)
+	if r.maxSize > 0 && r.size > r.maxSize {
+		return n, &ResponseTooLargeError{URL: r.url, Size: r.size, MaxSize: r.maxSize}
+	}
+	if r.warnSize > 0 && r.size > r.warnSize && !r.warned {
+		r.warned = true
+		log.Printf("response size of %s exceeds warn response size %d", r.url, r.warnSize)
+	}
+	return n, err
+}
+
+// responseSizeLimits 请求中的设置优先, 小于0表示不限制
+func (eng *CrawlEngine) responseSizeLimits(req *Request) (int64, int64) {
+	maxSize, warnSize := eng.Settings.MaxResponseSize, eng.Settings.WarnResponseSize
+	if req.MaxResponseSize != 0 {
+		maxSize = req.MaxResponseSize
+	}
+	if req.WarnResponseSize != 0 {
+		warnSize = req.WarnResponseSize
+	}
+	return maxSize, warnSize
+}
+
+// readResponse 读取响应, 流式请求返回未读取的body, 由调用者负责关闭
+func (eng *CrawlEngine) readResponse(response *http.Response, req *Request) (*Response, io.ReadCloser, error) {
+	maxSize, warnSize := eng.responseSizeLimits(req)
+	if maxSize > 0 && response.ContentLength > maxSize {
+		response.Body.Close()
+		return nil, nil, &ResponseTooLargeError{URL: req.URL, Size: response.ContentLength, MaxSize: maxSize}
+	}
+	bodyReader, err := openBody(response)
+	if err != nil {
+		response.Body.Close()
+		return nil, nil, err
+	}
+	body := &struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: &sizeLimitReader{reader: bodyReader, url: req.URL, maxSize: maxSize, warnSize: warnSize},
+		Closer: closerFunc(func() error {
+			bodyReader.Close()
+			return response.Body.Close()
+		}),
+	}
+
+	res := newResponse(response)
+	if isRedirect(response.StatusCode) || (req.StreamCallback == nil && !req.SaveBodyToFile) {
+		defer body.Close()
+		if res.Body, err = ioutil.ReadAll(body); err != nil {
+			return nil, nil, err
+		}
+		return res, nil, nil
+	}
+	if req.StreamCallback != nil {
+		return res, body, nil
+	}
+
+	defer body.Close()
+	file, err := ioutil.TempFile(req.DownloadDir, "crawler-")
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, nil, err
+	}
+	res.BodyFile = file.Name()
+	return res, nil, nil
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+func isRedirect(statusCode int) bool {
+	return statusCode == 301 || statusCode == 302 || statusCode == 303 || statusCode == 307
+}
@@ -0,0 +1,68 @@
+package crawler
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestResponseSizeLimit(t *testing.T) {
+	content := strings.Repeat("x", 4096)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chunked" {
+			w.(http.Flusher).Flush()
+		}
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	var mutex sync.Mutex
+	var tooLarge int
+	var streamed, saved string
+	NewCrawler(&Settings{AutoParseHtml: true, MaxResponseSize: 1024}).
+		OnRequestError(func(req *Request, err error, ctx *Context) {
+			var sizeErr *ResponseTooLargeError
+			if errors.As(err, &sizeErr) {
+				mutex.Lock()
+				tooLarge++
+				mutex.Unlock()
+			} else {
+				t.Error(err)
+			}
+		}).
+		WithStartRequests(func(ctx *Context) []*Request {
+			return []*Request{
+				GetURL(server.URL + "/fixed"),
+				GetURL(server.URL + "/chunked"),
+				GetURL(server.URL + "/stream").WithMaxResponseSize(-1).OnStream(func(res *Response, body io.Reader, ctx *Context) {
+					data, _ := ioutil.ReadAll(body)
+					mutex.Lock()
+					streamed = string(data)
+					mutex.Unlock()
+				}),
+				GetURL(server.URL + "/file").WithMaxResponseSize(8192).ToTempFile(t.TempDir()).OnResponse(func(res *Response, ctx *Context) {
+					data, _ := ioutil.ReadFile(res.BodyFile)
+					os.Remove(res.BodyFile)
+					mutex.Lock()
+					saved = string(data)
+					mutex.Unlock()
+				}),
+			}
+		}).
+		Start(true)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if tooLarge != 2 {
+		t.Errorf("got %d ResponseTooLargeError, want 2", tooLarge)
+	}
+	if streamed != content || saved != content {
+		t.Errorf("streamed %d bytes, saved %d bytes, want %d", len(streamed), len(saved), len(content))
+	}
+}
@@ -5,11 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net"
 	"net/http"
 	"net/url"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -29,6 +31,14 @@ type CrawlEngine struct {
 	RequestMetaMap     *sync.Map //map[*http.Request]Meta
 	requestingChan     chan *Request
 	processingItemChan chan bool
+	incrementalStore   IncrementalStore
+	// ownsIncrementalStore incrementalStore由引擎按Settings创建, 结束爬取时关闭
+	ownsIncrementalStore bool
+	feedExporter         *FeedExporter
+	// Stats 爬取统计
+	Stats *Stats
+	// pending 已入队还未处理完的请求和item数, 入队前增加, 处理完后减少
+	pending int32
 }
 
 type itemWrapper struct {
@@ -113,10 +123,35 @@ func newCrawlerEngine(settings *Settings) *CrawlEngine {
 		ItemQueue:    make(chan *itemWrapper, 1000),
 		//requestingChan: make(chan *Request, settings.MaxConcurrentRequests),
 		RequestMetaMap: &sync.Map{},
+		Stats:          NewStats(),
 	}
 
 	eng.httpClient = creatHttpClient(settings.Transport, eng)
 
+	if settings.Incremental {
+		if settings.IncrementalStorePath != "" {
+			store, err := NewFileIncrementalStore(settings.IncrementalStorePath)
+			if err != nil {
+				log.Printf("open incremental store %s failed, falling back to memory: %v", settings.IncrementalStorePath, err)
+				store = NewMemoryIncrementalStore()
+			}
+			eng.incrementalStore = store
+			eng.ownsIncrementalStore = true
+		} else {
+			eng.incrementalStore = NewMemoryIncrementalStore()
+		}
+	}
+
+	if len(settings.Feeds) > 0 {
+		exporter, err := NewFeedExporter(settings.Feeds)
+		if err != nil {
+			log.Printf("create feed exporter failed: %v", err)
+		} else {
+			exporter.Stats = eng.Stats
+		}
+		eng.feedExporter = exporter
+	}
+
 	//eng.fastHttpClient = &fasthttp.D
 
 	return eng
@@ -145,11 +180,23 @@ func (eng *CrawlEngine) StartProcessItems() {
 			hasInc = true
 
 			/* pipeline 执行顺序
-			** 1）先执行指定类型的处理函数
+			** 1）先执行HandleItem注册的类型处理函数和OnItemType注册的处理函数
 			** 2）若上步返回值非空则继续执行通用处理函数
 			** 3）若上步返回值非空继续按顺序执行pipeline列表中的pipeline
 			 */
 
+			if item != nil {
+				if handler := eng.crawler.itemHandler(reflect.TypeOf(item)); handler != nil {
+					newItem, err := handler(item, ctx)
+					if err != nil {
+						eng.itemFailed(item, err, reflect.TypeOf(item).String()+" handler", ctx)
+						item = nil
+					} else if newItem != nil {
+						item = newItem
+					}
+				}
+			}
+
 			if item != nil && len(eng.crawler.ItemTypeFuncs) > 0 {
 				if len(eng.crawler.ItemTypeFuncs) > 1 || eng.crawler.ItemTypeFuncs["*"] == nil {
 					itemType := reflect.TypeOf(item).String()
@@ -163,17 +210,26 @@ func (eng *CrawlEngine) StartProcessItems() {
 				}
 			}
 
+			var commits []func(bool)
 			if item != nil && ctx.Crawler.Pipelines != nil && len(ctx.Crawler.Pipelines) > 0 {
-				for _, pipeline := range ctx.Crawler.Pipelines {
-					newItem := pipeline.ProcessItem(item, ctx)
-					if newItem != nil {
-						item = newItem
+				item, commits = eng.runPipelines(item, ctx)
+			}
+
+			if item != nil {
+				eng.Stats.Inc("item/scraped_count")
+				passed := true
+				if eng.feedExporter != nil {
+					if err := eng.feedExporter.Export(item); err != nil {
+						log.Println(err)
+						passed = false
 					}
 				}
+				commitItem(commits, passed)
 			}
 			<-eng.processingItemChan
 			//atomic.AddInt32(&eng.ProcessingItemCount, -1)
 			hasInc = false
+			eng.done()
 		}
 	}
 
@@ -182,6 +238,64 @@ func (eng *CrawlEngine) StartProcessItems() {
 	}
 }
 
+// runPipelines 按顺序执行pipeline, 同时返回ItemCommitter的commit;
+// item被丢弃或处理失败时记录统计、调用回调, 以false调用已有的commit并返回nil
+func (eng *CrawlEngine) runPipelines(item interface{}, ctx *Context) (interface{}, []func(bool)) {
+	var commits []func(bool)
+	for _, pipeline := range ctx.Crawler.Pipelines {
+		var newItem interface{}
+		var commit func(bool)
+		var err error
+		switch p := pipeline.(type) {
+		case ItemCommitter:
+			newItem, commit, err = p.ProcessItemCommit(item, ctx)
+		case ItemPipelineE:
+			newItem, err = p.ProcessItemE(item, ctx)
+		default:
+			newItem = pipeline.ProcessItem(item, ctx)
+		}
+		if commit != nil {
+			commits = append(commits, commit)
+		}
+		if err != nil {
+			eng.itemFailed(item, err, pipelineName(pipeline), ctx)
+			commitItem(commits, false)
+			return nil, nil
+		}
+		if newItem != nil {
+			item = newItem
+		}
+	}
+	return item, commits
+}
+
+// commitItem 以item的最终结果调用ItemCommitter的commit
+func commitItem(commits []func(bool), passed bool) {
+	for _, commit := range commits {
+		commit(passed)
+	}
+}
+
+// itemFailed 记录被丢弃或处理失败的item并调用回调, source为出错的pipeline或处理函数
+func (eng *CrawlEngine) itemFailed(item interface{}, err error, source string, ctx *Context) {
+	var dropped *DroppedItemError
+	if errors.As(err, &dropped) {
+		eng.Stats.Inc("item/dropped_count")
+		eng.Stats.Inc("item/dropped_reasons_count/" + dropped.statsCode())
+		if eng.crawler.itemDroppedCallback != nil {
+			eng.crawler.itemDroppedCallback(item, err, ctx)
+		}
+		return
+	}
+	eng.Stats.Inc("item/error_count")
+	eng.Stats.Inc("item/error_count/" + source)
+	if eng.crawler.itemErrorCallback != nil {
+		eng.crawler.itemErrorCallback(item, err, ctx)
+	} else {
+		log.Printf("process item failed in %s: %v", source, err)
+	}
+}
+
 func (eng *CrawlEngine) processResponseCallback(req *Request, res *Response) {
 	req.context.LastResponse = res
 	// &Response{Body: body, Status: response.Status, StatusCode: response.StatusCode, Request: req}
@@ -193,7 +307,17 @@ func (eng *CrawlEngine) processResponseCallback(req *Request, res *Response) {
 	}
 }
 
-func (eng CrawlEngine) processRequestErrorCallback(req *Request, err error) {
+func (eng *CrawlEngine) processUnchangedCallback(req *Request, res *Response) {
+	req.context.LastResponse = res
+	if req.UnchangedCallback != nil {
+		req.UnchangedCallback(res, req.context)
+	}
+	if eng.crawler.unchangedCallback != nil {
+		eng.crawler.unchangedCallback(res, req.context)
+	}
+}
+
+func (eng *CrawlEngine) processRequestErrorCallback(req *Request, err error) {
 	if req.ErrorCallback != nil {
 		req.ErrorCallback(req, err, req.context)
 	}
@@ -206,6 +330,7 @@ func (eng CrawlEngine) processRequestErrorCallback(req *Request, err error) {
 func (eng *CrawlEngine) StartProcessRequests() {
 
 	worker := func(req *Request) *Request {
+		defer eng.done()
 		defer func() {
 			<-eng.requestingChan
 		}()
@@ -234,6 +359,7 @@ func (eng *CrawlEngine) StartProcessRequests() {
 			defer eng.RequestMetaMap.Delete(request)
 		}
 		//request.WithContext(_context)
+		eng.prepareConditionalRequest(req, request)
 
 		response, err := eng.httpClient.Do(request)
 
@@ -244,6 +370,8 @@ func (eng *CrawlEngine) StartProcessRequests() {
 
 			if req.retryTimes < eng.Settings.MaxRetryTimes {
 				req.retryTimes++
+				// 在本次请求处理完之前计入pending, 避免重试入队前引擎被判断为空闲
+				atomic.AddInt32(&eng.pending, 1)
 				go ctx.retry(req)
 			} else {
 				eng.processRequestErrorCallback(req, err)
@@ -252,11 +380,16 @@ func (eng *CrawlEngine) StartProcessRequests() {
 		} else {
 			// response.Header
 
-			res := NewResponse(response).WithRequest(req)
+			res, stream, err := eng.readResponse(response, req)
+			if err != nil {
+				eng.processRequestErrorCallback(req, err)
+				return req
+			}
+			res.WithRequest(req)
 
 			//fmt.Println(res.StatusCode)
 
-			if res.StatusCode == 301 || res.StatusCode == 302 || res.StatusCode == 303 || res.StatusCode == 307 {
+			if isRedirect(res.StatusCode) {
 				//	处理重定向
 				redirectUrl := res.Headers.Get("Location")
 
@@ -270,18 +403,27 @@ func (eng *CrawlEngine) StartProcessRequests() {
 						result := eng.crawler.redirectCallback(res, newReq, ctx)
 
 						if result != nil {
-							eng.RequestQueue <- result
+							eng.enqueueRequest(result)
 						} else {
 							//eng.processResponseCallback(req, res)
 						}
 					} else {
 						//redirectUrl := res.Headers.Get("Location")
-						eng.RequestQueue <- newReq
+						eng.enqueueRequest(newReq)
 					}
 				}
 
+			} else if stream != nil {
+				req.context.LastResponse = res
+				req.StreamCallback(res, stream, ctx)
+				stream.Close()
 			} else {
-				eng.processResponseCallback(req, res)
+				eng.checkUnchanged(req, res)
+				if res.Unchanged {
+					eng.processUnchangedCallback(req, res)
+				} else {
+					eng.processResponseCallback(req, res)
+				}
 			}
 		}
 
@@ -329,9 +471,26 @@ func (eng *CrawlEngine) WaitTime(seconds time.Duration) {
 	}
 }
 
-// IsIdle 判断引擎是否进入空闲状态
+// IsIdle 判断引擎是否进入空闲状态, 即没有已入队还未处理完的请求和item
 func (eng *CrawlEngine) IsIdle() bool {
-	return !eng.isProcessingRequests() && !eng.isProcessingItems()
+	return atomic.LoadInt32(&eng.pending) == 0
+}
+
+// enqueueRequest 请求入队
+func (eng *CrawlEngine) enqueueRequest(req *Request) {
+	atomic.AddInt32(&eng.pending, 1)
+	eng.RequestQueue <- req
+}
+
+// enqueueItem item入队
+func (eng *CrawlEngine) enqueueItem(item *itemWrapper) {
+	atomic.AddInt32(&eng.pending, 1)
+	eng.ItemQueue <- item
+}
+
+// done 一个请求或item处理完成
+func (eng *CrawlEngine) done() {
+	atomic.AddInt32(&eng.pending, -1)
 }
 
 func (eng *CrawlEngine) doRequest(u, method string, depth int, requestData io.Reader, ctx *Context, hdr http.Header, req *http.Request) error {
@@ -405,13 +564,3 @@ func (eng *CrawlEngine) doRequest(u, method string, depth int, requestData io.Re
 
 	return nil
 }
-
-// isProcessingRequests 判断是否有请求正在处理, 或者还未处理
-func (eng *CrawlEngine) isProcessingRequests() bool {
-	return len(eng.requestingChan) > 0 || len(eng.RequestQueue) > 0
-}
-
-// isProcessingItems 判断是否有Item正在处理, 或者还未处理
-func (eng *CrawlEngine) isProcessingItems() bool {
-	return len(eng.processingItemChan) > 0 || len(eng.ItemQueue) > 0
-}
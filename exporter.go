@@ -0,0 +1,381 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// ItemExporter 将item按某种格式写入一个输出文件
+type ItemExporter interface {
+	ExportItem(item interface{}) error
+	// Finish 写入格式结尾, 不关闭底层writer
+	Finish() error
+}
+
+// ItemExporterFactory 在w上创建ItemExporter
+type ItemExporterFactory func(w io.Writer, options *FeedOptions) ItemExporter
+
+var (
+	feedFormats      = map[string]ItemExporterFactory{}
+	feedFormatsMutex sync.RWMutex
+)
+
+func init() {
+	RegisterFeedFormat("jsonlines", newJSONLinesExporter)
+	RegisterFeedFormat("json", newJSONExporter)
+	RegisterFeedFormat("csv", newCSVExporter)
+	RegisterFeedFormat("xml", newXMLExporter)
+}
+
+// RegisterFeedFormat 注册导出格式, 可覆盖内置的jsonlines、json、csv和xml
+func RegisterFeedFormat(format string, factory ItemExporterFactory) {
+	feedFormatsMutex.Lock()
+	defer feedFormatsMutex.Unlock()
+	feedFormats[strings.ToLower(format)] = factory
+}
+
+func feedFormat(format string) (ItemExporterFactory, bool) {
+	feedFormatsMutex.RLock()
+	defer feedFormatsMutex.RUnlock()
+	factory, ok := feedFormats[strings.ToLower(format)]
+	return factory, ok
+}
+
+// itemField item的一个字段, 值为JSON编码
+type itemField struct {
+	Name  string
+	Value json.RawMessage
+}
+
+// marshalItem JSON编码, 不转义html字符; 使用了crawler标签的结构体按ItemSchema的字段名和顺序编码
+func marshalItem(item interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	schema, err := SchemaOf(item)
+	if err != nil {
+		return nil, err
+	}
+	if schema != nil && schema.Tagged {
+		if values := schema.Values(item); values != nil {
+			buf.WriteByte('{')
+			for i, f := range schema.Fields {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				if err := encoder.Encode(f.Name); err != nil {
+					return nil, err
+				}
+				buf.Truncate(buf.Len() - 1)
+				buf.WriteByte(':')
+				if err := encoder.Encode(values[i].Interface()); err != nil {
+					return nil, err
+				}
+				buf.Truncate(buf.Len() - 1)
+			}
+			buf.WriteByte('}')
+			return buf.Bytes(), nil
+		}
+	}
+	if err := encoder.Encode(item); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// itemFields 按JSON编码的顺序返回item的字段, 结构体保持字段顺序, map按键排序;
+// 非对象的item作为名为value的单个字段
+func itemFields(item interface{}) ([]itemField, error) {
+	data, err := marshalItem(item)
+	if err != nil {
+		return nil, err
+	}
+	return objectFields(data)
+}
+
+func objectFields(data []byte) ([]itemField, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		return []itemField{{Name: "value", Value: data}}, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	var fields []itemField
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		fields = append(fields, itemField{Name: token.(string), Value: value})
+	}
+	return fields, nil
+}
+
+// selectFields 按names的顺序选出字段, names为空时返回全部字段, 缺失的字段值为null
+func selectFields(fields []itemField, names []string) []itemField {
+	if len(names) == 0 {
+		return fields
+	}
+	values := make(map[string]json.RawMessage, len(fields))
+	for _, f := range fields {
+		values[f.Name] = f.Value
+	}
+	selected := make([]itemField, len(names))
+	for i, name := range names {
+		value, ok := values[name]
+		if !ok {
+			value = json.RawMessage("null")
+		}
+		selected[i] = itemField{Name: name, Value: value}
+	}
+	return selected
+}
+
+// encodeItem item的JSON编码, 指定Fields时只保留这些字段并按其排序
+func encodeItem(item interface{}, names []string) ([]byte, error) {
+	if len(names) == 0 {
+		return marshalItem(item)
+	}
+	fields, err := itemFields(item)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range selectFields(fields, names) {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(f.Name)
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(f.Value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// fieldText 字段的文本形式, 字符串不带引号, null为空
+func fieldText(value json.RawMessage) string {
+	value = bytes.TrimSpace(value)
+	if len(value) == 0 || string(value) == "null" {
+		return ""
+	}
+	if value[0] == '"' {
+		var s string
+		if json.Unmarshal(value, &s) == nil {
+			return s
+		}
+	}
+	return string(value)
+}
+
+type jsonLinesExporter struct {
+	w      io.Writer
+	fields []string
+}
+
+func newJSONLinesExporter(w io.Writer, options *FeedOptions) ItemExporter {
+	return &jsonLinesExporter{w: w, fields: options.Fields}
+}
+
+func (e *jsonLinesExporter) ExportItem(item interface{}) error {
+	data, err := encodeItem(item, e.fields)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(append(data, '\n'))
+	return err
+}
+
+func (e *jsonLinesExporter) Finish() error {
+	return nil
+}
+
+type jsonExporter struct {
+	w      io.Writer
+	fields []string
+	count  int
+}
+
+func newJSONExporter(w io.Writer, options *FeedOptions) ItemExporter {
+	return &jsonExporter{w: w, fields: options.Fields}
+}
+
+func (e *jsonExporter) ExportItem(item interface{}) error {
+	data, err := encodeItem(item, e.fields)
+	if err != nil {
+		return err
+	}
+	prefix := ",\n"
+	if e.count == 0 {
+		prefix = "[\n"
+	}
+	e.count++
+	_, err = io.WriteString(e.w, prefix+string(data))
+	return err
+}
+
+func (e *jsonExporter) Finish() error {
+	end := "\n]\n"
+	if e.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(e.w, end)
+	return err
+}
+
+type csvExporter struct {
+	w      *csv.Writer
+	fields []string
+	header bool
+}
+
+func newCSVExporter(w io.Writer, options *FeedOptions) ItemExporter {
+	return &csvExporter{w: csv.NewWriter(w), fields: options.Fields}
+}
+
+// ExportItem 首个item前写入表头, 未指定Fields时以首个item的字段作为列
+func (e *csvExporter) ExportItem(item interface{}) error {
+	fields, err := itemFields(item)
+	if err != nil {
+		return err
+	}
+	if !e.header {
+		if len(e.fields) == 0 {
+			for _, f := range fields {
+				e.fields = append(e.fields, f.Name)
+			}
+		}
+		if err := e.w.Write(e.fields); err != nil {
+			return err
+		}
+		e.header = true
+	}
+	fields = selectFields(fields, e.fields)
+	record := make([]string, len(fields))
+	for i, f := range fields {
+		record[i] = fieldText(f.Value)
+	}
+	if err := e.w.Write(record); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvExporter) Finish() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type xmlExporter struct {
+	w       io.Writer
+	fields  []string
+	started bool
+}
+
+func newXMLExporter(w io.Writer, options *FeedOptions) ItemExporter {
+	return &xmlExporter{w: w, fields: options.Fields}
+}
+
+func (e *xmlExporter) start() error {
+	if e.started {
+		return nil
+	}
+	e.started = true
+	_, err := io.WriteString(e.w, xml.Header+"<items>\n")
+	return err
+}
+
+func (e *xmlExporter) ExportItem(item interface{}) error {
+	fields, err := itemFields(item)
+	if err != nil {
+		return err
+	}
+	if err := e.start(); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	buf.WriteString("  <item>")
+	for _, f := range selectFields(fields, e.fields) {
+		if err := writeXMLElement(&buf, f.Name, f.Value); err != nil {
+			return err
+		}
+	}
+	buf.WriteString("</item>\n")
+	_, err = e.w.Write(buf.Bytes())
+	return err
+}
+
+func (e *xmlExporter) Finish() error {
+	if err := e.start(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, "</items>\n")
+	return err
+}
+
+// writeXMLElement 写入<name>value</name>, 对象展开为子元素, 数组的每个元素写为<value>
+func writeXMLElement(buf *bytes.Buffer, name string, value json.RawMessage) error {
+	name = xmlName(name)
+	value = bytes.TrimSpace(value)
+	buf.WriteString("<" + name + ">")
+	switch {
+	case len(value) == 0:
+	case value[0] == '{':
+		fields, err := objectFields(value)
+		if err != nil {
+			return err
+		}
+		for _, f := range fields {
+			if err := writeXMLElement(buf, f.Name, f.Value); err != nil {
+				return err
+			}
+		}
+	case value[0] == '[':
+		var values []json.RawMessage
+		if err := json.Unmarshal(value, &values); err != nil {
+			return err
+		}
+		for _, v := range values {
+			if err := writeXMLElement(buf, "value", v); err != nil {
+				return err
+			}
+		}
+	default:
+		if err := xml.EscapeText(buf, []byte(fieldText(value))); err != nil {
+			return err
+		}
+	}
+	buf.WriteString("</" + name + ">")
+	return nil
+}
+
+// xmlName 将字段名转换为合法的XML元素名
+func xmlName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		valid := unicode.IsLetter(r) || r == '_' || (i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.'))
+		if !valid {
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
@@ -0,0 +1,291 @@
+package crawler
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FeedOptions 一个导出目标的配置
+type FeedOptions struct {
+	// Format 导出格式: jsonlines、json、csv、xml或RegisterFeedFormat注册的格式, 为空时根据扩展名判断
+	Format string
+	// Fields 导出的字段及顺序, 为空时导出全部字段, CSV以首个item的字段作为列
+	Fields []string
+	// MaxItems 每个文件的最大item数, 达到后写入新文件, 0表示不限制
+	MaxItems int
+	// MaxSize 每个文件的最大字节数, 达到后写入新文件, 0表示不限制
+	MaxSize int64
+	// Storage 存储后端, 为空时根据uri的scheme选择
+	Storage FeedStorage
+	// URIParams uri模板的自定义参数
+	URIParams map[string]interface{}
+}
+
+// errFeedClosed 导出结束后又收到item
+var errFeedClosed = errors.New("feed is closed")
+
+// FeedExporter 将item导出到Settings.Feeds中配置的目标, 可被多个item worker并发调用
+//
+// 输出uri支持%(name)s形式的模板参数:
+// name为爬虫名称, time为开始导出的时间, batch_time为批次开始的时间, batch_id为从1开始的批次号,
+// 以及FeedOptions.URIParams中的参数; 轮转时uri中没有batch_id和batch_time则在扩展名前加上批次号
+type FeedExporter struct {
+	// Name 爬虫名称, 用于uri模板
+	Name string
+	// StartTime 开始导出的时间, 用于uri模板, 为空时使用创建时间
+	StartTime time.Time
+	// Stats 记录各存储后端写入成功和失败的批次数
+	Stats *Stats
+	feeds []*feed
+	mutex sync.RWMutex
+}
+
+// NewFeedExporter 按uri到配置的映射创建FeedExporter
+func NewFeedExporter(feeds map[string]FeedOptions) (*FeedExporter, error) {
+	uris := make([]string, 0, len(feeds))
+	for uri := range feeds {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	exporter := &FeedExporter{StartTime: time.Now()}
+	for _, uri := range uris {
+		if err := exporter.AddFeed(uri, feeds[uri]); err != nil {
+			return nil, err
+		}
+	}
+	return exporter, nil
+}
+
+// AddFeed 添加导出目标
+func (fe *FeedExporter) AddFeed(uri string, options FeedOptions) error {
+	format := options.Format
+	if format == "" {
+		format = formatFromExt(uri)
+	}
+	factory, ok := feedFormat(format)
+	if !ok {
+		return fmt.Errorf("unknown feed format %q for %s", format, redactURI(uri))
+	}
+	storage := options.Storage
+	if storage == nil {
+		var err error
+		if storage, err = feedStorage(uri); err != nil {
+			return fmt.Errorf("%v for %s", err, redactURI(uri))
+		}
+	}
+	fe.mutex.Lock()
+	defer fe.mutex.Unlock()
+	fe.feeds = append(fe.feeds, &feed{exporter: fe, uri: uri, options: options, factory: factory, storage: storage})
+	return nil
+}
+
+// formatFromExt 根据扩展名判断导出格式, 无法判断时为jsonlines
+func formatFromExt(uri string) string {
+	switch strings.ToLower(path.Ext(uri)) {
+	case ".json":
+		return "json"
+	case ".csv":
+		return "csv"
+	case ".xml":
+		return "xml"
+	}
+	return "jsonlines"
+}
+
+// Export 将item写入所有导出目标
+func (fe *FeedExporter) Export(item interface{}) error {
+	fe.mutex.RLock()
+	defer fe.mutex.RUnlock()
+	var errs []string
+	for _, f := range fe.feeds {
+		if err := f.export(item); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", redactURI(f.uri), err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("export item failed: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// ProcessItem 实现ItemPipeline接口
+func (fe *FeedExporter) ProcessItem(item interface{}, ctx *Context) interface{} {
+	return processItemE(fe, item, ctx)
+}
+
+// ProcessItemE 实现ItemPipelineE接口
+func (fe *FeedExporter) ProcessItemE(item interface{}, ctx *Context) (interface{}, error) {
+	return item, fe.Export(item)
+}
+
+// Close 写入格式结尾并关闭所有文件, 之后收到的item不再导出
+func (fe *FeedExporter) Close() error {
+	fe.mutex.RLock()
+	defer fe.mutex.RUnlock()
+	var errs []string
+	for _, f := range fe.feeds {
+		if err := f.close(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", redactURI(f.uri), err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("close feeds failed: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// feed 一个导出目标, 按MaxItems和MaxSize轮转文件
+type feed struct {
+	exporter *FeedExporter
+	uri      string
+	options  FeedOptions
+	factory  ItemExporterFactory
+	storage  FeedStorage
+	mutex    sync.Mutex
+	target   string
+	out      io.WriteCloser
+	buf      *bufio.Writer
+	counter  *countingWriter
+	items    ItemExporter
+	batch    int
+	count    int
+	closed   bool
+	// uploads 已结束但还在提交到存储后端的批次
+	uploads sync.WaitGroup
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+func (f *feed) rotated() bool {
+	return f.options.MaxItems > 0 || f.options.MaxSize > 0
+}
+
+// batchURI 当前批次的uri
+func (f *feed) batchURI() string {
+	now := time.Now()
+	start := f.exporter.StartTime
+	if start.IsZero() {
+		start = now
+	}
+	params := map[string]interface{}{
+		"name":       f.exporter.Name,
+		"time":       start.Format(feedTimeFormat),
+		"batch_time": now.Format(feedTimeFormat),
+		"batch_id":   f.batch,
+	}
+	for k, v := range f.options.URIParams {
+		params[k] = v
+	}
+	uri := f.uri
+	if f.rotated() && !strings.Contains(uri, "%(batch_id)") && !strings.Contains(uri, "%(batch_time)") {
+		ext := path.Ext(uri)
+		uri = strings.TrimSuffix(uri, ext) + "." + strconv.Itoa(f.batch) + ext
+	}
+	return expandFeedURI(uri, params)
+}
+
+// feedTimeFormat uri模板中的时间格式, 不含冒号以便用作文件名
+const feedTimeFormat = "2006-01-02T15-04-05"
+
+func (f *feed) open() error {
+	f.batch++
+	f.target = f.batchURI()
+	out, err := f.storage.Open(f.target)
+	if err != nil {
+		f.exporter.Stats.Inc("feed/failed_count/" + feedScheme(f.target))
+		return err
+	}
+	f.out = out
+	f.buf = bufio.NewWriter(out)
+	f.counter = &countingWriter{w: f.buf}
+	f.items = f.factory(f.counter, &f.options)
+	f.count = 0
+	return nil
+}
+
+func (f *feed) export(item interface{}) error {
+	f.mutex.Lock()
+	if f.closed {
+		f.mutex.Unlock()
+		return errFeedClosed
+	}
+	if f.items == nil {
+		if err := f.open(); err != nil {
+			f.mutex.Unlock()
+			return err
+		}
+	}
+	if err := f.items.ExportItem(item); err != nil {
+		f.mutex.Unlock()
+		return err
+	}
+	f.count++
+	var commit func() error
+	if (f.options.MaxItems > 0 && f.count >= f.options.MaxItems) || (f.options.MaxSize > 0 && f.counter.n >= f.options.MaxSize) {
+		commit = f.finish()
+	}
+	f.mutex.Unlock()
+	if commit != nil {
+		return commit()
+	}
+	return nil
+}
+
+// finish 结束当前批次, 下一个item写入新批次; 返回提交到存储后端的函数,
+// 须在释放锁之后调用, 以免上传阻塞其他item的写入
+func (f *feed) finish() func() error {
+	if f.items == nil {
+		return nil
+	}
+	err := f.items.Finish()
+	if flushErr := f.buf.Flush(); err == nil {
+		err = flushErr
+	}
+	out, target := f.out, f.target
+	f.items, f.out, f.buf, f.counter = nil, nil, nil, nil
+	f.uploads.Add(1)
+	return func() error {
+		defer f.uploads.Done()
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		scheme := feedScheme(target)
+		if err != nil {
+			f.exporter.Stats.Inc("feed/failed_count/" + scheme)
+			return fmt.Errorf("store %s failed: %w", redactURI(target), err)
+		}
+		f.exporter.Stats.Inc("feed/success_count/" + scheme)
+		return nil
+	}
+}
+
+// close 提交最后一个批次并等待所有批次提交完成
+func (f *feed) close() error {
+	f.mutex.Lock()
+	f.closed = true
+	commit := f.finish()
+	f.mutex.Unlock()
+	var err error
+	if commit != nil {
+		err = commit()
+	}
+	f.uploads.Wait()
+	return err
+}
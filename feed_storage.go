@@ -0,0 +1,120 @@
+package crawler
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// FeedStorage 导出文件的存储后端, 每个批次调用一次Open, Close时完成写入或上传
+type FeedStorage interface {
+	Open(uri string) (io.WriteCloser, error)
+}
+
+var (
+	feedStorages      = map[string]FeedStorage{}
+	feedStoragesMutex sync.RWMutex
+)
+
+func init() {
+	RegisterFeedStorage("file", &FileFeedStorage{})
+	RegisterFeedStorage("stdout", &StdoutFeedStorage{})
+	RegisterFeedStorage("s3", &S3FeedStorage{})
+}
+
+// RegisterFeedStorage 注册uri scheme对应的存储后端, 可覆盖内置的file、stdout和s3
+func RegisterFeedStorage(scheme string, storage FeedStorage) {
+	feedStoragesMutex.Lock()
+	defer feedStoragesMutex.Unlock()
+	feedStorages[strings.ToLower(scheme)] = storage
+}
+
+// feedScheme uri的scheme, 本地路径(包括Windows盘符)为file, "-"为stdout
+func feedScheme(uri string) string {
+	if uri == "-" {
+		return "stdout"
+	}
+	if i := strings.Index(uri, ":"); i > 1 {
+		scheme := strings.ToLower(uri[:i])
+		if !strings.ContainsAny(scheme, `/\.`) {
+			return scheme
+		}
+	}
+	return "file"
+}
+
+func feedStorage(uri string) (FeedStorage, error) {
+	scheme := feedScheme(uri)
+	feedStoragesMutex.RLock()
+	defer feedStoragesMutex.RUnlock()
+	storage, ok := feedStorages[scheme]
+	if !ok {
+		return nil, fmt.Errorf("unknown feed storage %q", scheme)
+	}
+	return storage, nil
+}
+
+var feedURIParam = regexp.MustCompile(`%\((\w+)\)(\d*)([sd])`)
+
+// expandFeedURI 替换uri中%(name)s、%(batch_id)05d形式的参数, 未知参数保持不变
+func expandFeedURI(uri string, params map[string]interface{}) string {
+	return feedURIParam.ReplaceAllStringFunc(uri, func(m string) string {
+		match := feedURIParam.FindStringSubmatch(m)
+		value, ok := params[match[1]]
+		if !ok {
+			return m
+		}
+		return fmt.Sprintf("%"+match[2]+match[3], value)
+	})
+}
+
+// redactURI 隐藏uri中的账号信息, 用于日志和错误
+func redactURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		if i := strings.Index(uri, "@"); i >= 0 {
+			if j := strings.Index(uri, "://"); j >= 0 && j < i {
+				return uri[:j+3] + "xxxxx" + uri[i:]
+			}
+		}
+		return uri
+	}
+	if u.User == nil {
+		return uri
+	}
+	u.User = url.User("xxxxx")
+	return u.String()
+}
+
+// FileFeedStorage 本地文件, 自动创建目录
+type FileFeedStorage struct{}
+
+// Open 实现FeedStorage接口
+func (s *FileFeedStorage) Open(uri string) (io.WriteCloser, error) {
+	path := strings.TrimPrefix(uri, "file://")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
+// StdoutFeedStorage 标准输出, Close时不关闭
+type StdoutFeedStorage struct{}
+
+// Open 实现FeedStorage接口
+func (s *StdoutFeedStorage) Open(uri string) (io.WriteCloser, error) {
+	return nopWriteCloser{os.Stdout}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
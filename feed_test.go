@@ -0,0 +1,172 @@
+package crawler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+type feedTestItem struct {
+	Title string   `json:"title"`
+	Price float64  `json:"price"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+func TestFeedExporterFormats(t *testing.T) {
+	dir := t.TempDir()
+	exporter, err := NewFeedExporter(map[string]FeedOptions{
+		filepath.Join(dir, "items.jsonl"): {},
+		filepath.Join(dir, "items.json"):  {Fields: []string{"price", "title"}},
+		filepath.Join(dir, "items.csv"):   {Fields: []string{"title", "price", "missing"}},
+		filepath.Join(dir, "items.xml"):   {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter.Export(&feedTestItem{Title: "a,b", Price: 1.5, Tags: []string{"x", "y"}})
+	exporter.Export(map[string]interface{}{"title": "<c>", "price": 2})
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Export(&feedTestItem{}); err == nil {
+		t.Error("export after Close should fail")
+	}
+
+	read := func(name string) string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	want := "{\"title\":\"a,b\",\"price\":1.5,\"tags\":[\"x\",\"y\"]}\n{\"price\":2,\"title\":\"<c>\"}\n"
+	if got := read("items.jsonl"); got != want {
+		t.Errorf("jsonlines = %q", got)
+	}
+	var items []map[string]interface{}
+	if err := json.Unmarshal([]byte(read("items.json")), &items); err != nil || len(items) != 2 || items[0]["tags"] != nil {
+		t.Errorf("json = %q, %v", read("items.json"), err)
+	}
+	if got := read("items.json"); !strings.HasPrefix(got, "[\n{\"price\":1.5,\"title\":\"a,b\"}") {
+		t.Errorf("json field order = %q", got)
+	}
+	if got := read("items.csv"); got != "title,price,missing\n\"a,b\",1.5,\n<c>,2,\n" {
+		t.Errorf("csv = %q", got)
+	}
+	xml := read("items.xml")
+	if !strings.Contains(xml, "<item><title>a,b</title><price>1.5</price><tags><value>x</value><value>y</value></tags></item>") ||
+		!strings.Contains(xml, "<title>&lt;c&gt;</title>") || !strings.HasSuffix(xml, "</items>\n") {
+		t.Errorf("xml = %q", xml)
+	}
+}
+
+func TestFeedExporterRotation(t *testing.T) {
+	dir := t.TempDir()
+	exporter, err := NewFeedExporter(map[string]FeedOptions{
+		filepath.Join(dir, "out", "items.jsonl"): {MaxItems: 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 6; j++ {
+				exporter.Export(map[string]int{"n": j})
+			}
+		}()
+	}
+	wg.Wait()
+	exporter.Close()
+
+	for name, lines := range map[string]int{"items.1.jsonl": 10, "items.2.jsonl": 10, "items.3.jsonl": 4} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "out", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Count(string(data), "\n"); got != lines {
+			t.Errorf("%s has %d lines, want %d", name, got, lines)
+		}
+	}
+
+	if _, err := NewFeedExporter(map[string]FeedOptions{"items.bin": {Format: "bin"}}); err == nil {
+		t.Error("unknown format should fail")
+	}
+}
+
+func TestCrawlerFeeds(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<h1>" + r.URL.Path + "</h1>"))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "items.json")
+	var started, stopped int32
+	c := NewCrawler(&Settings{AutoParseHtml: true, Feeds: map[string]FeedOptions{path: {}}}).
+		ClearPipelines().
+		OnStart(func(ctx *Context) { atomic.AddInt32(&started, 1) }).
+		OnStop(func(ctx *Context) { atomic.AddInt32(&stopped, 1) }).
+		OnResponse(func(res *Response, ctx *Context) {
+			ctx.Emit(map[string]string{"title": res.CSS("h1").Get()})
+		}).
+		WithStartRequests(func(ctx *Context) []*Request {
+			return []*Request{GetURL(server.URL + "/a"), GetURL(server.URL + "/b")}
+		}).
+		Start(false)
+	if c.IsIdle() {
+		t.Error("crawler should not be idle before the start requests are queued")
+	}
+	c.Wait()
+	if atomic.LoadInt32(&stopped) != 0 {
+		t.Error("Wait should not stop the crawler")
+	}
+	c.Stop()
+
+	if atomic.LoadInt32(&started) != 1 || atomic.LoadInt32(&stopped) != 1 {
+		t.Errorf("started = %d, stopped = %d", started, stopped)
+	}
+	var items []map[string]string
+	data, _ := ioutil.ReadFile(path)
+	if err := json.Unmarshal(data, &items); err != nil || len(items) != 2 {
+		t.Errorf("feed = %q, %v", data, err)
+	}
+}
+
+func TestExpandFeedURI(t *testing.T) {
+	params := map[string]interface{}{"name": "books", "batch_id": 3}
+	got := expandFeedURI("out/%(name)s/%(batch_id)05d-%(unknown)s.csv", params)
+	if got != "out/books/00003-%(unknown)s.csv" {
+		t.Errorf("expandFeedURI = %q", got)
+	}
+	for uri, scheme := range map[string]string{"items.csv": "file", "file:///tmp/a": "file", `C:\a.csv`: "file", "-": "stdout", "S3://b/k": "s3"} {
+		if got := feedScheme(uri); got != scheme {
+			t.Errorf("feedScheme(%q) = %q, want %q", uri, got, scheme)
+		}
+	}
+}
+
+func TestRedactURI(t *testing.T) {
+	for uri, want := range map[string]string{
+		"s3://AKID:SECRET@bucket/items.csv": "s3://xxxxx@bucket/items.csv",
+		"s3://AKID:SE%zz@bucket/items.csv":  "s3://xxxxx@bucket/items.csv",
+		"s3://bucket/items.csv":             "s3://bucket/items.csv",
+		"out/items.csv":                     "out/items.csv",
+	} {
+		if got := redactURI(uri); got != want {
+			t.Errorf("redactURI(%q) = %q, want %q", uri, got, want)
+		}
+	}
+	err := (&FeedExporter{}).AddFeed("s3://AKID:SECRET@bucket/items.csv", FeedOptions{Format: "yaml"})
+	if err == nil || strings.Contains(err.Error(), "SECRET") {
+		t.Errorf("AddFeed error = %v", err)
+	}
+}
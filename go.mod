@@ -1,11 +1,47 @@
 module github.com/qhzhyt/go-crawler
 
-go 1.13
+go 1.18
 
 require (
+	github.com/andybalholm/brotli v1.0.6
 	github.com/antchfx/xpath v1.1.10
 	github.com/golang/groupcache v0.0.0-20200121045136-8c9f03a8e57e
-	golang.org/x/net v0.0.0-20201006153459-a7d1128ccaa0
+	github.com/klauspost/compress v1.16.7
+	github.com/lib/pq v1.10.9
+	go.mongodb.org/mongo-driver v1.11.9
+	golang.org/x/net v0.0.0-20211112202133-69e39bad7dc2
+	golang.org/x/text v0.3.7
+	modernc.org/sqlite v1.20.4
+)
+
+require (
+	github.com/dustin/go-humanize v1.0.0 // indirect
+	github.com/golang/snappy v0.0.1 // indirect
+	github.com/google/uuid v1.3.0 // indirect
+	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51 // indirect
+	github.com/mattn/go-isatty v0.0.16 // indirect
+	github.com/montanaflynn/stats v0.0.0-20171201202039-1bf9dbcd8cbe // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20200410134404-eec4a21b6bb0 // indirect
+	github.com/xdg-go/pbkdf2 v1.0.0 // indirect
+	github.com/xdg-go/scram v1.1.1 // indirect
+	github.com/xdg-go/stringprep v1.0.3 // indirect
+	github.com/youmark/pkcs8 v0.0.0-20181117223130-1be2e3e5546d // indirect
+	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d // indirect
+	golang.org/x/mod v0.3.0 // indirect
+	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
+	golang.org/x/sys v0.0.0-20220811171246-fbc7d0a398ab // indirect
+	golang.org/x/tools v0.0.0-20201124115921-2c860bdd6e78 // indirect
+	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
+	lukechampine.com/uint128 v1.2.0 // indirect
+	modernc.org/cc/v3 v3.40.0 // indirect
+	modernc.org/ccgo/v3 v3.16.13 // indirect
+	modernc.org/libc v1.22.2 // indirect
+	modernc.org/mathutil v1.5.0 // indirect
+	modernc.org/memory v1.4.0 // indirect
+	modernc.org/opt v0.1.3 // indirect
+	modernc.org/strutil v1.1.3 // indirect
+	modernc.org/token v1.0.1 // indirect
 )
 
 // replace github.com/qhzhyt/go-crawler => ./
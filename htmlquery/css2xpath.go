@@ -2,9 +2,11 @@ package htmlquery
 
 // from github.com/moovweb/
 import (
+	"bytes"
 	"fmt"
 	// "rubex"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -57,6 +59,9 @@ const (
 	PRECEDES
 	ParentOf
 	AncestorOf
+	PseudoElement
+	AttrIdent
+	AttrFlag
 	// and a counter ... I can't believe I didn't think of this sooner
 	NumLexemes
 )
@@ -69,8 +74,8 @@ func init() {
 	pattern[SPACES] = `\s+`
 	pattern[COMMA] = `\s*,`
 	pattern[UNIVERSAL] = `\*`
-	pattern[TYPE] = `[_a-zA-Z]\w*`
-	pattern[ELEMENT] = `(\*|[_a-zA-Z]\w*)`
+	pattern[TYPE] = `[_a-zA-Z][-\w]*`
+	pattern[ELEMENT] = `(\*|[_a-zA-Z][-\w]*)`
 	pattern[CLASS] = `\.[-\w]+`
 	pattern[ID] = `\#[-\w]+`
 	pattern[LBRACKET] = `\[`
@@ -105,11 +110,14 @@ func init() {
 	pattern[OPERATOR] = `[-+]`
 	pattern[PLUS] = `\+`
 	pattern[MINUS] = `-`
-	pattern[BINOMIAL] = strings.Join([]string{pattern[COEFFICIENT], pattern[N], `\s*`, pattern[OPERATOR], `\s*`, pattern[UNSIGNED]}, "")
+	pattern[BINOMIAL] = strings.Join([]string{pattern[COEFFICIENT], pattern[N], `(\s*`, pattern[OPERATOR], `\s*`, pattern[UNSIGNED], `)?`}, "")
 	pattern[AdjacentTo] = `\s*\+`
 	pattern[PRECEDES] = `\s*~`
 	pattern[ParentOf] = `\s*>`
 	pattern[AncestorOf] = `\s+`
+	pattern[PseudoElement] = `::[-a-z]+`
+	pattern[AttrIdent] = `-?[_a-zA-Z0-9][-\w]*`
+	pattern[AttrFlag] = `[iIsS]`
 	for i, p := range pattern {
 		matcher[i] = regexp.MustCompile(`\A` + p)
 	}
@@ -122,14 +130,73 @@ type Scope int
 const (
 	GLOBAL = iota
 	LOCAL
+	// MATCH 以节点为上下文求值时, 节点匹配css则选中该节点本身, 用于判断单个节点是否匹配
+	MATCH
 )
 
-// CSS2Xpath 将css转为xpath
+const (
+	upperCase = "'ABCDEFGHIJKLMNOPQRSTUVWXYZ'"
+	lowerCase = "'abcdefghijklmnopqrstuvwxyz'"
+)
+
+// SelectorError 选择器语法错误, Offset为出错位置, 无法确定位置时为-1
+type SelectorError struct {
+	Selector string
+	Offset   int
+	Msg      string
+}
+
+func (e *SelectorError) Error() string {
+	if e.Offset < 0 {
+		return fmt.Sprintf("invalid selector %q: %s", e.Selector, e.Msg)
+	}
+	return fmt.Sprintf("invalid selector %q at offset %d: %s", e.Selector, e.Offset, e.Msg)
+}
+
+// syntaxError 转换过程中出错时panic的值, rest为出错位置之后的输入
+type syntaxError struct {
+	msg  string
+	rest []byte
+}
+
+func fail(input []byte, msg string) {
+	panic(&syntaxError{msg: msg, rest: input})
+}
+
+// CSS2Xpath 将css转为xpath, css无效时panic
+//
+// See `TryCSS2Xpath()` function.
 func CSS2Xpath(css string, scope Scope) string {
-	xpath, _ := selectors([]byte(css), scope)
+	xpath, err := TryCSS2Xpath(css, scope)
+	if err != nil {
+		panic(err)
+	}
 	return xpath
 }
 
+// TryCSS2Xpath 将css转为xpath, css无效时返回*SelectorError
+func TryCSS2Xpath(css string, scope Scope) (xpath string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*syntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = &SelectorError{Selector: css, Offset: len(css) - len(e.rest), Msg: e.msg}
+		}
+	}()
+	var rest []byte
+	if scope == MATCH {
+		xpath, rest = matchSelectors([]byte(css))
+	} else {
+		xpath, rest = selectors([]byte(css), scope)
+	}
+	if len(bytes.TrimSpace(rest)) > 0 {
+		fail(rest, fmt.Sprintf("Unexpected %q.", rest[0]))
+	}
+	return xpath, nil
+}
+
 func selectors(input []byte, scope Scope) (string, []byte) {
 	x, input := selector(input, scope)
 	xs := []string{x}
@@ -147,14 +214,30 @@ func selector(input []byte, scope Scope) (string, []byte) {
 	if scope == LOCAL {
 		xs = []string{"."}
 	}
+	if peek(PseudoElement, input) {
+		// 单独的伪元素作用于当前节点, 如::text
+		if scope == GLOBAL {
+			xs = []string{"."}
+		}
+		x, input := pseudoElement(input)
+		return strings.Join(append(xs, x), ""), input
+	}
+	// 相对选择器可以以任意组合符开头, 如:has(> p)、:has(+ p)
 	if matched, remainder := token(ParentOf, input); matched != nil {
 		combinator, input = ParentOf, remainder
+	} else if matched, remainder := token(AdjacentTo, input); matched != nil && scope == LOCAL {
+		combinator, input = AdjacentTo, remainder
+	} else if matched, remainder := token(PRECEDES, input); matched != nil && scope == LOCAL {
+		combinator, input = PRECEDES, remainder
 	} else {
 		combinator = AncestorOf
 	}
 	x, input := sequence(input, combinator)
 	xs = append(xs, x)
 	for {
+		if peek(RPAREN, input) || peek(COMMA, input) || len(bytes.TrimSpace(input)) == 0 {
+			break
+		}
 		if matched, remainder := token(AdjacentTo, input); matched != nil {
 			combinator, input = AdjacentTo, remainder
 		} else if matched, remainder := token(PRECEDES, input); matched != nil {
@@ -166,12 +249,95 @@ func selector(input []byte, scope Scope) (string, []byte) {
 		} else {
 			break
 		}
+		if combinator == AncestorOf && peek(PseudoElement, input) {
+			// "a ::text" 选择所有后代节点的文本
+			xs = append(xs, "/descendant-or-self::*")
+			break
+		}
 		x, input = sequence(input, combinator)
 		xs = append(xs, x)
 	}
+	if peek(PseudoElement, input) {
+		x, input = pseudoElement(input)
+		xs = append(xs, x)
+	}
 	return strings.Join(xs, ""), input
 }
 
+// matchSelectors 转换MATCH作用域的选择器列表
+func matchSelectors(input []byte) (string, []byte) {
+	x, input := matchSelector(input)
+	xs := []string{x}
+	for peek(COMMA, input) {
+		_, input = token(COMMA, input)
+		x, input = matchSelector(input)
+		xs = append(xs, x)
+	}
+	return strings.Join(xs, " | "), input
+}
+
+// matchSelector 从右往左转换复杂选择器: 最后一个复合选择器匹配当前节点, 之前的复合选择器沿组合符反方向匹配,
+// 如"div > p"转为self::*[(self::p) and parent::*[self::div]]
+func matchSelector(input []byte) (string, []byte) {
+	x, input := sequence(input, NOT)
+	for {
+		if peek(RPAREN, input) || peek(COMMA, input) || len(bytes.TrimSpace(input)) == 0 {
+			break
+		}
+		var axis string
+		if matched, remainder := token(AdjacentTo, input); matched != nil {
+			axis, input = "preceding-sibling::*[1]", remainder
+		} else if matched, remainder := token(PRECEDES, input); matched != nil {
+			axis, input = "preceding-sibling::*", remainder
+		} else if matched, remainder := token(ParentOf, input); matched != nil {
+			axis, input = "parent::*", remainder
+		} else if matched, remainder := token(AncestorOf, input); matched != nil {
+			axis, input = "ancestor::*", remainder
+		} else {
+			break
+		}
+		if peek(PseudoElement, input) {
+			break
+		}
+		var p string
+		p, input = sequence(input, NOT)
+		x = fmt.Sprintf("(%s) and %s[%s]", p, axis, x)
+	}
+	if peek(PseudoElement, input) {
+		fail(input, "Pseudo-elements cannot be matched against an element.")
+	}
+	return "self::*[" + x + "]", input
+}
+
+// pseudoElement 转换Scrapy风格的伪元素, ::text选择文本节点, ::attr(name)选择属性
+func pseudoElement(input []byte) (string, []byte) {
+	start := input
+	element, input := token(PseudoElement, input)
+	switch string(element) {
+	case "::text":
+		return "/text()", input
+	case "::attr":
+		lparen, input := token(LPAREN, input)
+		if lparen == nil {
+			fail(input, "::attr requires a parenthesized argument.")
+		}
+		_, input = token(SPACES, input)
+		name, input := token(AttrName, input)
+		if name == nil {
+			fail(input, "::attr requires an attribute name.")
+		}
+		_, input = token(SPACES, input)
+		rparen, input := token(RPAREN, input)
+		if rparen == nil {
+			fail(input, "Unterminated argument to ::attr.")
+		}
+		return "/@" + string(name), input
+	}
+	fail(start, `Cannot convert CSS pseudo-element "`+string(element)+`" to XPath.`)
+	return "", input
+}
+
+// sequence 转换一个复合选择器, combinator为NOT时返回不带方括号的谓词
 func sequence(input []byte, combinator Lexeme) (string, []byte) {
 	_, input = token(SPACES, input)
 	x, ps := "", []string{}
@@ -184,101 +350,137 @@ func sequence(input []byte, combinator Lexeme) (string, []byte) {
 	case PRECEDES:
 		x = "/following-sibling::*"
 	case AdjacentTo:
-		x = "/following-sibling::*"
-		ps = append(ps, "position()=1")
+		x = "/following-sibling::*[1]"
 	}
 
+	element := "*"
 	if e, remainder := token(ELEMENT, input); e != nil {
-		input = remainder
-		if len(ps) > 0 {
-			ps = append(ps, " and ")
-		}
-		ps = append(ps, "self::"+string(e))
-		if !(peek(ID, input) || peek(CLASS, input) || peek(PseudoClass, input) || peek(LBRACKET, input)) {
-			pstr := strings.Join(ps, "")
-			if pstr != "" {
-				pstr = fmt.Sprintf("[%s]", pstr)
-			}
-			return x + pstr, input
-		}
+		// HTML解析后的标签名均为小写
+		element, input = strings.ToLower(string(e)), remainder
+		ps = append(ps, "self::"+element)
 	}
-	q, input, connective := qualifier(input)
-	if q == "" {
-		panic("Invalid CSS selector")
-	}
-	if len(ps) > 0 {
-		ps = append(ps, connective)
+	for {
+		q, remainder := qualifier(input, element)
+		if q == "" {
+			break
+		}
+		input = remainder
+		ps = append(ps, q)
 	}
-	ps = append(ps, q)
-	for q, r, c := qualifier(input); q != ""; q, r, c = qualifier(input) {
-		ps, input = append(ps, c, q), r
+	if len(ps) == 0 {
+		fail(input, "Invalid CSS selector")
 	}
-	pstr := strings.Join(ps, "")
+	pstr := strings.Join(ps, " and ")
 	if combinator != NOT {
 		pstr = fmt.Sprintf("[%s]", pstr)
 	}
 	return x + pstr, input
 }
 
-func qualifier(input []byte) (string, []byte, string) {
-	p, connective := "", ""
+// qualifier 转换类、id、属性或伪类, element为复合选择器的标签名, 未指定时为*
+func qualifier(input []byte, element string) (string, []byte) {
+	p := ""
 	if t, remainder := token(CLASS, input); t != nil {
 		p = fmt.Sprintf(`contains(concat(" ", @class, " "), " %s ")`, string(t[1:]))
 		input = remainder
-		connective = " and "
 	} else if t, remainder := token(ID, input); t != nil {
-		p, input, connective = fmt.Sprintf(`@id="%s"`, string(t[1:])), remainder, " and "
+		p, input = fmt.Sprintf(`@id="%s"`, string(t[1:])), remainder
 	} else if peek(PseudoClass, input) {
-		p, input, connective = pseudoClass(input)
+		p, input = pseudoClass(input, element)
 	} else if peek(LBRACKET, input) {
 		p, input = attribute(input)
-		connective = " and "
 	}
-	return p, input, connective
+	return p, input
+}
+
+// siblingIndex 元素在同类兄弟节点中的位置, fromEnd为true时从后往前数
+func siblingIndex(element string, fromEnd bool) string {
+	axis := "preceding-sibling"
+	if fromEnd {
+		axis = "following-sibling"
+	}
+	return fmt.Sprintf("(count(%s::%s) + 1)", axis, element)
 }
 
-func pseudoClass(input []byte) (string, []byte, string) {
+func pseudoClass(input []byte, element string) (string, []byte) {
+	start := input
 	class, input := token(PseudoClass, input)
-	var p, connective string
-	switch string(class) {
+	var p string
+	switch name := string(class); name {
 	case ":first-child":
-		p, connective = "position()=1", " and "
-	case ":first-of-type":
-		p, connective = "position()=1", "]["
+		p = "count(preceding-sibling::*) = 0"
 	case ":last-child":
-		p, connective = "position()=last()", " and "
-	case ":last-of-type":
-		p, connective = "position()=last()", "]["
+		p = "count(following-sibling::*) = 0"
 	case ":only-child":
-		p, connective = "position() = 1 and position() = last()", " and "
-	case ":only-of-type":
-		p, connective = "position() = 1 and position() = last()", "]["
+		p = "count(preceding-sibling::*) = 0 and count(following-sibling::*) = 0"
+	case ":first-of-type", ":last-of-type", ":only-of-type":
+		ofType(start, name, element)
+		switch name {
+		case ":first-of-type":
+			p = fmt.Sprintf("count(preceding-sibling::%s) = 0", element)
+		case ":last-of-type":
+			p = fmt.Sprintf("count(following-sibling::%s) = 0", element)
+		default:
+			p = fmt.Sprintf("count(preceding-sibling::%s) = 0 and count(following-sibling::%s) = 0", element, element)
+		}
 	case ":nth-child":
-		p, input = nth(input)
-		connective = " and "
-	case ":nth-of-type":
-		p, input = nth(input)
-		connective = "]["
+		p, input = nth(input, siblingIndex("*", false))
+	case ":nth-last-child":
+		p, input = nth(input, siblingIndex("*", true))
+	case ":nth-of-type", ":nth-last-of-type":
+		ofType(start, name, element)
+		p, input = nth(input, siblingIndex(element, name == ":nth-last-of-type"))
 	case ":not":
 		p, input = negate(input)
-		connective = " and "
+	case ":is", ":where", ":matches", ":any":
+		p, input = matches(input, name)
+	case ":has":
+		p, input = has(input)
+	case ":contains":
+		p, input = containsText(input)
+	case ":root":
+		// 根元素的父节点是文档节点, 文档节点没有父节点
+		p = "parent::node() and not(../..)"
+	case ":empty":
+		p = "not(*) and not(text())"
+	case ":checked":
+		p = `((self::input and (@type="checkbox" or @type="radio") and @checked) or (self::option and @selected))`
+	case ":disabled":
+		p = "(@disabled and (self::button or self::input or self::select or self::textarea or self::option or self::optgroup or self::fieldset))"
+	case ":enabled":
+		p = "(not(@disabled) and (self::button or self::input or self::select or self::textarea or self::option or self::optgroup or self::fieldset))"
+	case ":link", ":any-link":
+		p = "((self::a or self::area or self::link) and @href)"
+	case ":visited", ":hover", ":active", ":focus", ":focus-within", ":focus-visible", ":target":
+		// 静态文档中不存在用户交互状态
+		p = "false()"
+	case ":lang":
+		p, input = lang(input)
 	default:
-		panic(`Cannot convert CSS pseudo-class "` + string(class) + `" to XPath.`)
+		fail(start, `Cannot convert CSS pseudo-class "`+name+`" to XPath.`)
+	}
+	return p, input
+}
+
+// ofType *-of-type伪类需要标签名, XPath 1.0无法表达"与自身同名的兄弟节点"
+func ofType(input []byte, name string, element string) {
+	if element == "*" {
+		fail(input, name+" requires an element name.")
 	}
-	return p, input, connective
 }
 
-func nth(input []byte) (string, []byte) {
+// nth 转换an+b参数, index为元素位置的XPath表达式
+func nth(input []byte, index string) (string, []byte) {
 	lparen, input := token(LPAREN, input)
 	if lparen == nil {
-		panic(":nth-child and :nth-of-type require an parenthesized argument")
+		fail(input, ":nth-* pseudo-classes require an parenthesized argument")
 	}
 	_, input = token(SPACES, input)
 	var expr string
 	if e, rem := token(EVEN, input); e != nil {
-		expr, input = "position() mod 2 = 0", rem
+		expr, input = index+" mod 2 = 0", rem
 	} else if e, rem := token(ODD, input); e != nil {
-		expr, input = "position() mod 2 = 1", rem
+		expr, input = index+" mod 2 = 1", rem
 	} else if e, _ := token(BINOMIAL, input); e != nil {
 		var coefficient, operator, constant []byte
 		coefficient, input = token(COEFFICIENT, input)
@@ -293,45 +495,144 @@ func nth(input []byte) (string, []byte) {
 		operator, input = token(OPERATOR, input)
 		_, input = token(SPACES, input)
 		constant, input = token(UNSIGNED, input)
-		expr = fmt.Sprintf("(position() %s %s) mod %s = 0", invert(string(operator)), string(constant), string(coefficient))
+		if operator != nil && constant == nil {
+			fail(input, "Expected a number after the sign in :nth-* pseudo-class.")
+		}
+		a, _ := strconv.Atoi(string(coefficient))
+		b, _ := strconv.Atoi(string(constant))
+		if string(operator) == "-" {
+			b = -b
+		}
+		expr = binomial(index, a, b)
 	} else if e, rem := token(SIGNED, input); e != nil {
-		expr, input = "position() = "+string(e), rem
+		b, _ := strconv.Atoi(string(e))
+		expr, input = binomial(index, 0, b), rem
 	} else {
-		panic("Invalid argument to :nth-child or :nth-of-type.")
+		fail(input, "Invalid argument to :nth-* pseudo-class.")
 	}
-	fmt.Println(string(input))
 	_, input = token(SPACES, input)
 	rparen, input := token(RPAREN, input)
 	if rparen == nil {
-		panic("Unterminated argument to :nth-child or :nth-of-type.")
+		fail(input, "Unterminated argument to :nth-* pseudo-class.")
 	}
 	return expr, input
 }
 
-func invert(op string) string {
-	op = strings.TrimSpace(op)
-	if op == "+" {
-		op = "-"
-	} else {
-		op = "+"
+// binomial 存在n>=0使index = a*n + b
+func binomial(index string, a, b int) string {
+	offset := index
+	if b > 0 {
+		offset = fmt.Sprintf("(%s - %d)", index, b)
+	} else if b < 0 {
+		offset = fmt.Sprintf("(%s + %d)", index, -b)
+	}
+	switch {
+	case a <= 0 && b < 1:
+		// 元素位置从1开始, 不存在匹配的元素
+		return "false()"
+	case a == 0:
+		return fmt.Sprintf("%s = %d", index, b)
+	case a == 1:
+		return fmt.Sprintf("%s >= %d", index, b)
+	case a == -1:
+		return fmt.Sprintf("%s <= %d", index, b)
+	case a > 0 && b <= 1:
+		return fmt.Sprintf("%s mod %d = 0", offset, a)
+	case a > 0:
+		return fmt.Sprintf("%s >= %d and %s mod %d = 0", index, b, offset, a)
 	}
-	return op
+	return fmt.Sprintf("%s <= %d and (%d - %s) mod %d = 0", index, b, b, index, -a)
+}
+
+// compoundList 解析括号内逗号分隔的复合选择器, 返回各选择器的谓词
+func compoundList(input []byte, name string) ([]string, []byte) {
+	_, input = token(SPACES, input)
+	lparen, input := token(LPAREN, input)
+	if lparen == nil {
+		fail(input, name+" requires a parenthesized argument.")
+	}
+	var ps []string
+	for {
+		_, input = token(SPACES, input)
+		var p string
+		p, input = sequence(input, NOT)
+		ps = append(ps, p)
+		if !peek(COMMA, input) {
+			break
+		}
+		_, input = token(COMMA, input)
+	}
+	_, input = token(SPACES, input)
+	rparen, input := token(RPAREN, input)
+	if rparen == nil {
+		fail(input, "Unterminated argument to "+name+".")
+	}
+	return ps, input
 }
 
 func negate(input []byte) (string, []byte) {
+	ps, input := compoundList(input, ":not")
+	if len(ps) == 1 {
+		return fmt.Sprintf("not(%s)", ps[0]), input
+	}
+	return fmt.Sprintf("not((%s) or (%s))", ps[0], strings.Join(ps[1:], ") or (")), input
+}
+
+func matches(input []byte, name string) (string, []byte) {
+	ps, input := compoundList(input, name)
+	return fmt.Sprintf("((%s))", strings.Join(ps, ") or (")), input
+}
+
+// has 转换:has(), 参数为相对于当前元素的选择器列表
+func has(input []byte) (string, []byte) {
+	_, input = token(SPACES, input)
+	lparen, input := token(LPAREN, input)
+	if lparen == nil {
+		fail(input, ":has requires a parenthesized argument.")
+	}
+	_, input = token(SPACES, input)
+	x, input := selectors(input, LOCAL)
+	_, input = token(SPACES, input)
+	rparen, input := token(RPAREN, input)
+	if rparen == nil {
+		fail(input, "Unterminated argument to :has.")
+	}
+	// 谓词中嵌套的位置谓词需要用count()包裹才能正确求值
+	return fmt.Sprintf("count(%s) > 0", x), input
+}
+
+// argument 解析单个带引号或不带引号的参数, 返回XPath字符串字面量
+func argument(input []byte, name string) (string, []byte) {
 	_, input = token(SPACES, input)
 	lparen, input := token(LPAREN, input)
 	if lparen == nil {
-		panic(":not requires a parenthesized argument.")
+		fail(input, name+" requires a parenthesized argument.")
 	}
 	_, input = token(SPACES, input)
-	p, input := sequence(input, NOT)
+	var val []byte
+	if val, input = token(AttrValue, input); val == nil {
+		if val, input = token(AttrIdent, input); val == nil {
+			fail(input, "Missing argument to "+name+".")
+		}
+		val = []byte(`"` + string(val) + `"`)
+	}
 	_, input = token(SPACES, input)
 	rparen, input := token(RPAREN, input)
 	if rparen == nil {
-		panic("Unterminated argument to :not.")
+		fail(input, "Unterminated argument to "+name+".")
 	}
-	return fmt.Sprintf("not(%s)", p), input
+	return string(val), input
+}
+
+func containsText(input []byte) (string, []byte) {
+	val, input := argument(input, ":contains")
+	return fmt.Sprintf("contains(string(.), %s)", val), input
+}
+
+func lang(input []byte) (string, []byte) {
+	val, input := argument(input, ":lang")
+	return fmt.Sprintf(`count(ancestor-or-self::*[@lang][1][starts-with(concat(translate(@lang, %s, %s), "-"), concat(translate(%s, %s, %s), "-"))]) > 0`,
+		upperCase, lowerCase, val, upperCase, lowerCase), input
 }
 
 func attribute(input []byte) (string, []byte) {
@@ -339,7 +640,7 @@ func attribute(input []byte) (string, []byte) {
 	_, input = token(SPACES, input)
 	name, input := token(AttrName, input)
 	if name == nil {
-		panic("Attribute selector requires an attribute name.")
+		fail(input, "Attribute selector requires an attribute name.")
 	}
 	_, input = token(SPACES, input)
 	if rbracket, remainder := token(RBRACKET, input); rbracket != nil {
@@ -347,35 +648,44 @@ func attribute(input []byte) (string, []byte) {
 	}
 	op, input := token(MatchOp, input)
 	if op == nil {
-		panic("Missing operator in attribute selector.")
+		fail(input, "Missing operator in attribute selector.")
 	}
 	_, input = token(SPACES, input)
 	val, input := token(AttrValue, input)
 	if val == nil {
-		panic("Missing value in attribute selector.")
+		if val, input = token(AttrIdent, input); val == nil {
+			fail(input, "Missing value in attribute selector.")
+		}
+		val = []byte(`"` + string(val) + `"`)
 	}
 	_, input = token(SPACES, input)
+	flag, input := token(AttrFlag, input)
+	_, input = token(SPACES, input)
 	rbracket, input := token(RBRACKET, input)
 	if rbracket == nil {
-		panic("Unterminated attribute selector.")
+		fail(input, "Unterminated attribute selector.")
 	}
 	var expr string
-	n, v := string(name), string(val)
+	n, v := "@"+string(name), string(val)
+	if strings.ToLower(string(flag)) == "i" {
+		// [attr=value i] 忽略ASCII大小写
+		n, v = fmt.Sprintf("translate(%s, %s, %s)", n, upperCase, lowerCase), strings.ToLower(v)
+	}
 	switch string(op) {
 	case "=":
-		expr = fmt.Sprintf("@%s=%s", n, v)
+		expr = fmt.Sprintf("%s=%s", n, v)
 	case "~=":
-		expr = fmt.Sprintf(`contains(concat(" ", @%s, " "), concat(" ", %s, " "))`, n, v)
+		expr = fmt.Sprintf(`contains(concat(" ", %s, " "), concat(" ", %s, " "))`, n, v)
 	case "|=":
-		expr = fmt.Sprintf(`(@%s=%s or starts-with(@%s, concat(%s, "-")))`, n, v, n, v)
+		expr = fmt.Sprintf(`(%s=%s or starts-with(%s, concat(%s, "-")))`, n, v, n, v)
 	case "^=":
-		expr = fmt.Sprintf("starts-with(@%s, %s)", n, v)
+		expr = fmt.Sprintf("starts-with(%s, %s)", n, v)
 	case "$=":
 		// oy, libxml doesn't support ends-with
 		// generate something like: div[substring(@class, string-length(@class) - string-length('foo') + 1) = 'foo']
-		expr = fmt.Sprintf("substring(@%s, string-length(@%s) - string-length(%s) + 1) = %s", n, n, v, v)
+		expr = fmt.Sprintf("substring(%s, string-length(%s) - string-length(%s) + 1) = %s", n, n, v, v)
 	case "*=":
-		expr = fmt.Sprintf("contains(@%s, %s)", n, v)
+		expr = fmt.Sprintf("contains(%s, %s)", n, v)
 	}
 	return expr, input
 }
@@ -0,0 +1,176 @@
+package htmlquery
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCSS2Xpath(t *testing.T) {
+	const any = "/descendant-or-self::*/*"
+	cases := []struct {
+		css   string
+		xpath string
+	}{
+		// CSS Selectors Level 3
+		{"*", any + "[self::*]"},
+		{"div", any + "[self::div]"},
+		{"DIV", any + "[self::div]"},
+		{"my-element", any + "[self::my-element]"},
+		{".a", any + `[contains(concat(" ", @class, " "), " a ")]`},
+		{"#main", any + `[@id="main"]`},
+		{"div.a#b", any + `[self::div and contains(concat(" ", @class, " "), " a ") and @id="b"]`},
+		{"[href]", any + "[@href]"},
+		{`[type="text"]`, any + `[@type="text"]`},
+		{"[type=text]", any + `[@type="text"]`},
+		{"[class~='a']", any + `[contains(concat(" ", @class, " "), concat(" ", 'a', " "))]`},
+		{"[lang|='en']", any + `[(@lang='en' or starts-with(@lang, concat('en', "-")))]`},
+		{"[href^='http']", any + "[starts-with(@href, 'http')]"},
+		{"[href$='.pdf']", any + "[substring(@href, string-length(@href) - string-length('.pdf') + 1) = '.pdf']"},
+		{"[href*='example']", any + "[contains(@href, 'example')]"},
+		{"div p", any + "[self::div]" + any + "[self::p]"},
+		{"div > p", any + "[self::div]/child::*[self::p]"},
+		{"h1 + p", any + "[self::h1]/following-sibling::*[1][self::p]"},
+		{"h1 ~ p", any + "[self::h1]/following-sibling::*[self::p]"},
+		{"h1, h2", any + "[self::h1] | " + any + "[self::h2]"},
+		{"li:first-child", any + "[self::li and count(preceding-sibling::*) = 0]"},
+		{"li:last-child", any + "[self::li and count(following-sibling::*) = 0]"},
+		{"li:only-child", any + "[self::li and count(preceding-sibling::*) = 0 and count(following-sibling::*) = 0]"},
+		{"p:first-of-type", any + "[self::p and count(preceding-sibling::p) = 0]"},
+		{"p:last-of-type", any + "[self::p and count(following-sibling::p) = 0]"},
+		{"p:only-of-type", any + "[self::p and count(preceding-sibling::p) = 0 and count(following-sibling::p) = 0]"},
+		{"li:nth-child(3)", any + "[self::li and (count(preceding-sibling::*) + 1) = 3]"},
+		{"li:nth-child(odd)", any + "[self::li and (count(preceding-sibling::*) + 1) mod 2 = 1]"},
+		{"li:nth-child(even)", any + "[self::li and (count(preceding-sibling::*) + 1) mod 2 = 0]"},
+		{"li:nth-child(2n+1)", any + "[self::li and ((count(preceding-sibling::*) + 1) - 1) mod 2 = 0]"},
+		{"li:nth-child(3n)", any + "[self::li and (count(preceding-sibling::*) + 1) mod 3 = 0]"},
+		{"li:nth-child(3n+4)", any + "[self::li and (count(preceding-sibling::*) + 1) >= 4 and ((count(preceding-sibling::*) + 1) - 4) mod 3 = 0]"},
+		{"li:nth-child(-n+3)", any + "[self::li and (count(preceding-sibling::*) + 1) <= 3]"},
+		{"li:nth-child(n)", any + "[self::li and (count(preceding-sibling::*) + 1) >= 0]"},
+		{"li:nth-last-child(2)", any + "[self::li and (count(following-sibling::*) + 1) = 2]"},
+		{"li:nth-of-type(2)", any + "[self::li and (count(preceding-sibling::li) + 1) = 2]"},
+		{"li:nth-last-of-type(-2n+5)", any + "[self::li and (count(following-sibling::li) + 1) <= 5 and (5 - (count(following-sibling::li) + 1)) mod 2 = 0]"},
+		{"div:not(p)", any + "[self::div and not(self::p)]"},
+		{"div:not(.a)", any + `[self::div and not(contains(concat(" ", @class, " "), " a "))]`},
+		{":root", any + "[parent::node() and not(../..)]"},
+		{"li:nth-child(-1)", any + "[self::li and false()]"},
+		{"li:nth-child(-n+0)", any + "[self::li and false()]"},
+		{"p:empty", any + "[self::p and not(*) and not(text())]"},
+		{"input:checked", any + `[self::input and ((self::input and (@type="checkbox" or @type="radio") and @checked) or (self::option and @selected))]`},
+		{"input:disabled", any + "[self::input and (@disabled and (self::button or self::input or self::select or self::textarea or self::option or self::optgroup or self::fieldset))]"},
+		{"a:link", any + "[self::a and ((self::a or self::area or self::link) and @href)]"},
+		{"a:hover", any + "[self::a and false()]"},
+		// Level 4 and jQuery extensions
+		{"div:not(.a, #b)", any + `[self::div and not((contains(concat(" ", @class, " "), " a ")) or (@id="b"))]`},
+		{":is(h1, h2).t", any + `[((self::h1) or (self::h2)) and contains(concat(" ", @class, " "), " t ")]`},
+		{":where(h1)", any + "[((self::h1))]"},
+		{"div:has(p)", any + "[self::div and count(." + any + "[self::p]) > 0]"},
+		{"div:has(> p, + span)", any + "[self::div and count(./child::*[self::p] | ./following-sibling::*[1][self::span]) > 0]"},
+		{"p:contains('hi')", any + "[self::p and contains(string(.), 'hi')]"},
+		{"[type='TEXT' i]", any + "[translate(@type, 'ABCDEFGHIJKLMNOPQRSTUVWXYZ', 'abcdefghijklmnopqrstuvwxyz')='text']"},
+		// pseudo-elements
+		{"a::text", any + "[self::a]/text()"},
+		{"a ::text", any + "[self::a]/descendant-or-self::*/text()"},
+		{"a::attr(href)", any + "[self::a]/@href"},
+	}
+	for _, c := range cases {
+		if got := CSS2Xpath(c.css, GLOBAL); got != c.xpath {
+			t.Errorf("CSS2Xpath(%q)\n got: %s\nwant: %s", c.css, got, c.xpath)
+		}
+	}
+}
+
+func TestCSSMatches(t *testing.T) {
+	doc := NewSelector([]byte(`<html id="root" lang="en-US"><body>
+		<ul><li id="1">one</li><li id="2">two</li><li id="3">three</li><li id="4">four</li><li id="5">five</li></ul>
+		<div id="d1"><h2 id="h">title</h2><p id="p1">first</p><p id="p2"></p><span id="s1">x</span></div>
+		<div id="d2"><span id="s2">y</span></div>
+		<form><input id="c1" type="checkbox" checked><input id="c2" type="CHECKBOX"><input id="t1" type="text" disabled></form>
+	</body></html>`))
+	cases := []struct {
+		css string
+		ids string
+	}{
+		{"li:nth-child(odd)", "1,3,5"},
+		{"li:nth-child(-n+2)", "1,2"},
+		{"li:nth-last-child(2)", "4"},
+		{"li:nth-child(3n-1)", "2,5"},
+		{"p:first-of-type", "p1"},
+		{"p:last-of-type", "p2"},
+		{"span:only-of-type", "s1,s2"},
+		{"p:empty", "p2"},
+		{"div:has(> h2)", "d1"},
+		{"h2:has(+ p), span:has(~ p)", "h"},
+		{":has(+ #p2)", "p1"},
+		{"div:has(p:contains('first'))", "d1"},
+		{"h2 + p", "p1"},
+		{"h2 ~ :is(p, span)", "p1,p2,s1"},
+		{"div > :first-child", "h,s2"},
+		{"div:not(:has(p))", "d2"},
+		{"input:checked", "c1"},
+		{"input:disabled", "t1"},
+		{"input[type=checkbox i]", "c1,c2"},
+		{"li:lang(en)", "1,2,3,4,5"},
+		{":root", "root"},
+		{"li:nth-child(-1)", ""},
+		{"li:nth-child(0)", ""},
+		{"li:nth-child(-2n+3)", "1,3"},
+	}
+	for _, c := range cases {
+		var ids []string
+		for _, s := range doc.CSS(c.css) {
+			ids = append(ids, s.Attr("id"))
+		}
+		if got := strings.Join(ids, ","); got != c.ids {
+			t.Errorf("CSS(%q) matched %q, want %q", c.css, got, c.ids)
+		}
+	}
+}
+
+func TestTryCSS2XpathErrors(t *testing.T) {
+	cases := []struct {
+		css    string
+		offset int
+	}{
+		{"", 0},
+		{"div[", 4},
+		{"a[href", 6},
+		{"a[href=]", 7},
+		{"li:nth-child(x)", 13},
+		{"li:nth-child(2n+)", 16},
+		{"p:unknown", 1},
+		{"p::before", 1},
+		{"div:not(p", 9},
+		{"a$", 1},
+		{"a, ", 3},
+		{"*:first-of-type", 1},
+		{".a:last-of-type", 2},
+		{"li [href]:nth-of-type(2)", 9},
+	}
+	for _, c := range cases {
+		_, err := TryCSS2Xpath(c.css, GLOBAL)
+		selectorErr, ok := err.(*SelectorError)
+		if !ok {
+			t.Errorf("TryCSS2Xpath(%q) error = %v, want *SelectorError", c.css, err)
+		} else if selectorErr.Offset != c.offset {
+			t.Errorf("TryCSS2Xpath(%q) offset = %d, want %d: %v", c.css, selectorErr.Offset, c.offset, err)
+		}
+	}
+
+	doc := NewSelector([]byte("<p>a</p>"))
+	if _, err := doc.TryCSS("p:unknown"); err == nil {
+		t.Error("TryCSS should return error for unsupported pseudo-class")
+	}
+	for path, offset := range map[string]int{"//p[": 4, "//p[@id='x]": 8} {
+		_, err := doc.TryXpath(path)
+		if selectorErr, ok := err.(*SelectorError); !ok || selectorErr.Offset != offset {
+			t.Errorf("TryXpath(%q) error = %v, want offset %d", path, err, offset)
+		}
+	}
+	// antchfx/xpath求值时panic的表达式
+	if _, err := doc.TryXpath("//p[count(preceding-sibling::*) = -1]"); err == nil {
+		t.Error("TryXpath should recover from evaluation panics")
+	}
+	if ss, err := doc.TryCSS("p"); err != nil || len(ss) != 1 {
+		t.Errorf("TryCSS(\"p\") = %v, %v", ss, err)
+	}
+}
@@ -1,8 +1,306 @@
 package htmlquery
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestGetQuery(t *testing.T) {
 	a, nil := getQueryByCSS("a", true)
 	t.Log(a, nil)
 }
+
+func TestPseudoElements(t *testing.T) {
+	doc := NewSelector([]byte(`<div><a class="title" href="/a">A<b>1</b></a><a class="title" href="/a">B</a><p>text</p></div>`))
+	cases := []struct {
+		css  string
+		want []string
+	}{
+		{"a.title::attr(href)", []string{"/a", "/a"}},
+		{"a::text", []string{"A", "B"}},
+		{"a ::text", []string{"A", "1", "B"}},
+		{"p::text, a b::text", []string{"text", "1"}},
+	}
+	for _, c := range cases {
+		got := doc.CSS(c.css).Getall()
+		if strings.Join(got, "|") != strings.Join(c.want, "|") {
+			t.Errorf("CSS(%q).Getall() = %q, want %q", c.css, got, c.want)
+		}
+	}
+
+	for _, a := range doc.CSS("a") {
+		if got := a.CSS("::attr(href)").Getall(); len(got) != 1 || got[0] != "/a" {
+			t.Errorf("relative ::attr(href) = %q", got)
+		}
+	}
+	if got := doc.CSS("p")[0].Get(); got != "<p>text</p>" {
+		t.Errorf("Get() on element = %q", got)
+	}
+}
+
+func TestRe(t *testing.T) {
+	doc := NewSelector([]byte(`<ul><li>price: 12.5 USD</li><li>price: 8 EUR</li><li>free</li></ul>`))
+	items := doc.CSS("li::text")
+	if got := items.Re(`\d+(?:\.\d+)?`); strings.Join(got, "|") != "12.5|8" {
+		t.Errorf("Re = %q", got)
+	}
+	if got := items.Re(`(\d+) (\w+)`); strings.Join(got, "|") != "5|USD|8|EUR" {
+		t.Errorf("Re with groups = %q", got)
+	}
+	if got := items[2].ReFirst(`\d+`, "0"); got != "0" {
+		t.Errorf("ReFirst default = %q", got)
+	}
+	groups := items.ReGroups(`(?P<amount>[\d.]+) (?P<currency>[A-Z]+)`)
+	if len(groups) != 2 || groups[0]["amount"] != "12.5" || groups[1]["currency"] != "EUR" {
+		t.Errorf("ReGroups = %v", groups)
+	}
+	if got := items[0].Re(`(`); len(got) != 0 {
+		t.Errorf("invalid pattern = %q", got)
+	}
+	if got, err := items[0].TryRe(`(`); err == nil || len(got) != 0 {
+		t.Errorf("TryRe invalid pattern = %q, %v", got, err)
+	}
+	if got, err := items.TryRe(`\d+ (\w+)`); err != nil || strings.Join(got, "|") != "USD|EUR" {
+		t.Errorf("TryRe = %q, %v", got, err)
+	}
+	if _, err := items.TryRe(`[`); err == nil {
+		t.Error("Selectors.TryRe should report invalid patterns")
+	}
+}
+
+func TestSelectorsAccessors(t *testing.T) {
+	doc := NewSelector([]byte(`<ul><li><a href="/1">one</a></li><li><a href="/2">two</a></li></ul>`))
+	empty := doc.CSS("table")
+	if empty.First().Exists() || empty.First().Text() != "" || empty.First().Attr("href") != "" {
+		t.Errorf("First() on empty set should be an empty selector")
+	}
+	if len(empty.First().CSS("a")) != 0 || len(empty.CSS("a").Xpath("//b")) != 0 {
+		t.Errorf("queries on empty set should be empty")
+	}
+	if empty.Get() != "" || empty.GetOr("none") != "none" || len(empty.Getall()) != 0 {
+		t.Errorf("Get/GetOr/Getall on empty set")
+	}
+	var nilSelector *Selector
+	if nilSelector.Get() != "" || len(nilSelector.Xpath("//a")) != 0 {
+		t.Errorf("nil selector should be safe")
+	}
+
+	items := doc.CSS("li")
+	if got := items.CSS("a::attr(href)").Getall(); strings.Join(got, "|") != "/1|/2" {
+		t.Errorf("Selectors.CSS = %q", got)
+	}
+	if got := items.Xpath("a/text()").Get(); got != "one" {
+		t.Errorf("Selectors.Xpath.Get = %q", got)
+	}
+	if got := items.CSS("a::text").GetOr("none"); got != "one" {
+		t.Errorf("GetOr = %q", got)
+	}
+}
+
+func TestTraversal(t *testing.T) {
+	doc := NewSelector([]byte(`<table class="info main"><tr><th>Name</th><td>Go</td></tr>
+<tr><th>Year</th> <td data-x="1"> 2009 <script>var x;</script>
+ <span>Nov</span></td></tr></table>`))
+	label := doc.Xpath(`//th[text()="Year"]`).First()
+	value := label.NextSibling()
+	if got := value.NormalizedText(); got != "2009 Nov" {
+		t.Errorf("NormalizedText = %q", got)
+	}
+	block := NewSelector([]byte(`<div><p>a</p><p>b<br>c</p><noscript>x</noscript><pre> d
+  e </pre></div>`)).CSS("div").First()
+	if got, want := block.NormalizedText(), strings.Join(strings.Fields(block.ReadableText()), " "); got != "a b c d e" || got != want {
+		t.Errorf("NormalizedText = %q, ReadableText = %q", got, want)
+	}
+	if value.PrevSibling().Text() != "Year" || value.NextSibling().Exists() {
+		t.Errorf("PrevSibling/NextSibling")
+	}
+	if value.Index() != 1 || label.Index() != 0 {
+		t.Errorf("Index = %d, %d", value.Index(), label.Index())
+	}
+	if got := value.Parent().Children().Texts(); len(got) != 2 || got[0] != "Year" {
+		t.Errorf("Parent().Children() = %q", got)
+	}
+	if attrs := value.Attrs(); attrs["data-x"] != "1" || len(attrs) != 1 {
+		t.Errorf("Attrs = %v", attrs)
+	}
+	table := value.Closest("table.main")
+	if !table.HasClass("info") || table.HasClass("inf") {
+		t.Errorf("Closest/HasClass")
+	}
+	if value.Closest("td").Node != value.Node || value.Closest("ul").Exists() {
+		t.Errorf("Closest should include self and return empty when missing")
+	}
+	for css, want := range map[string]string{
+		"tr > td":             "td",
+		"table tr:last-child": "tr",
+		"th + td, ul":         "td",
+		"th ~ td[data-x]":     "td",
+		"tbody > tr th":       "",
+		"div table":           "",
+		"table:root":          "",
+	} {
+		if got := value.Closest(css); (want == "" && got.Exists()) || (want != "" && (!got.Exists() || got.Node.Data != want)) {
+			t.Errorf("Closest(%q) = %v, want %q", css, got.Node, want)
+		}
+	}
+	if doc.CSS("ul").First().Parent().Exists() || doc.CSS("ul").First().Index() != -1 {
+		t.Errorf("traversal on empty selector")
+	}
+	var missing *Selector
+	if missing.HasClass("info") {
+		t.Errorf("HasClass on nil selector")
+	}
+}
+
+func TestInnerHTML(t *testing.T) {
+	doc := NewSelector([]byte(`<div><p>a</p><p>b</p></div><span>next</span>`))
+	if got := doc.CSS("div").First().InnerHTML(); got != "<p>a</p><p>b</p>" {
+		t.Errorf("InnerHTML = %q", got)
+	}
+}
+
+func TestReadableText(t *testing.T) {
+	doc := NewSelector([]byte(`<div id="a"><h1>  Title
+	</h1><script>var x = 1;</script><p>first <b>bold</b>
+	text<br>second line</p><noscript>enable js</noscript>
+	<ul><li>one</li><li>two</li></ul><table><tr><td>k</td><td>v</td></tr></table><pre>x  y
+z</pre></div>`))
+	div := doc.CSS("#a").First()
+	want := "Title\nfirst bold text\nsecond line\none\ntwo\nk v\nx  y\nz"
+	if got := div.ReadableText(); got != want {
+		t.Errorf("ReadableText = %q, want %q", got, want)
+	}
+	got := div.RenderText(TextOptions{LineSeparator: " | ", CellSeparator: "\t"})
+	if !strings.Contains(got, "k\tv") || strings.Contains(got, "enable js") || strings.Contains(got, "var x") {
+		t.Errorf("RenderText = %q", got)
+	}
+	got = div.RenderText(TextOptions{SkipTags: []string{}})
+	if !strings.Contains(got, "enable js") || !strings.Contains(got, "var x") {
+		t.Errorf("RenderText without SkipTags = %q", got)
+	}
+}
+
+func TestTable(t *testing.T) {
+	doc := NewSelector([]byte(`<div><table>
+<thead><tr><th rowspan="2">Name</th><th colspan="2">Price</th></tr>
+<tr><th>Min</th><th>Max</th></tr></thead>
+<tbody><tr><td rowspan="2"><b>Apple</b></td><td>1</td><td>2</td></tr>
+<tr><td>3</td><td>4</td></tr>
+<tr><td>Pear</td><td colspan="2">5</td></tr></tbody></table></div>`))
+	table := doc.CSS("div").First().Table()
+	if got := strings.Join(table.Header, "|"); got != "Name|Price Min|Price Max" {
+		t.Errorf("Header = %q", got)
+	}
+	want := [][]string{{"Apple", "1", "2"}, {"Apple", "3", "4"}, {"Pear", "5", "5"}}
+	if len(table.Rows) != len(want) {
+		t.Fatalf("Rows = %q", table.Rows)
+	}
+	for i := range want {
+		if strings.Join(table.Rows[i], "|") != strings.Join(want[i], "|") {
+			t.Errorf("Rows[%d] = %q, want %q", i, table.Rows[i], want[i])
+		}
+	}
+	records := table.Records()
+	if records[1]["Name"] != "Apple" || records[2]["Price Max"] != "5" {
+		t.Errorf("Records = %v", records)
+	}
+	cells := doc.CSS("table").First().TableWith(TableOptions{CellHTML: true})
+	if cells.Rows[0][0] != "<b>Apple</b>" {
+		t.Errorf("CellHTML = %q", cells.Rows[0][0])
+	}
+
+	doc = NewSelector([]byte(`<table><tr><th>k</th><th>k</th><th></th></tr><tr><td>a</td><td>b</td></tr></table>`))
+	records = doc.CSS("table").First().Table().Records()
+	if len(records) != 1 || records[0]["k"] != "a" || records[0]["k_2"] != "b" || records[0]["2"] != "" {
+		t.Errorf("Records with duplicate header = %v", records)
+	}
+	plain := doc.CSS("table").First().TableWith(TableOptions{NoHeader: true})
+	if len(plain.Rows) != 2 || plain.Records()[0]["0"] != "k" {
+		t.Errorf("NoHeader = %v", plain.Records())
+	}
+
+	doc = NewSelector([]byte(`<table><tbody><tr><td rowspan="0">a</td><td>1</td><td>x</td></tr>
+<tr><td>2</td><td colspan="2">y</td></tr><tr><td>3</td><td>z</td></tr></tbody>
+<tbody><tr><td>b</td><td>4</td><td>w</td></tr></tbody></table>`))
+	grid := doc.CSS("table").First().TableWith(TableOptions{NoHeader: true}).Rows
+	want = [][]string{{"a", "1", "x", ""}, {"a", "2", "y", "y"}, {"a", "3", "z", ""}, {"b", "4", "w", ""}}
+	if len(grid) != len(want) {
+		t.Fatalf("rowspan=0 Rows = %q", grid)
+	}
+	for i := range want {
+		if strings.Join(grid[i], "|") != strings.Join(want[i], "|") {
+			t.Errorf("rowspan=0 Rows[%d] = %q, want %q", i, grid[i], want[i])
+		}
+	}
+
+	doc = NewSelector([]byte(`<table><tr><td>a</td><td rowspan="2">b</td></tr><tr><td colspan="2">c</td></tr></table>`))
+	grid = doc.CSS("table").First().TableWith(TableOptions{NoHeader: true}).Rows
+	if len(grid) != 2 || strings.Join(grid[1], "|") != "c|b|c" {
+		t.Errorf("colspan over rowspan Rows = %q", grid)
+	}
+}
+
+func TestStructuredData(t *testing.T) {
+	doc := NewSelector([]byte(`<html><head>
+<meta property="og:title" content="Widget">
+<meta property="og:image" content="/1.png"><meta property="og:image" content="/2.png">
+<meta name="twitter:card" content="summary">
+<script type="application/ld+json">{"@context": "https://schema.org", "@graph": [
+  {"@type": "Organization", "name": "ACME"}, {"@type": "WebPage", "name": "Widget page"}]}</script>
+<script type="application/ld+json">{ invalid </script>
+</head><body>
+<div itemscope itemtype="https://schema.org/Product" itemref="brand">
+  <h1 itemprop="name">Widget</h1>
+  <img itemprop="image" src="/w.png">
+  <div itemprop="offers" itemscope itemtype="https://schema.org/Offer">
+    <meta itemprop="priceCurrency" content="USD"><span itemprop="price">9.99</span>
+  </div>
+  <span itemprop="color">red</span><span itemprop="color">blue</span>
+</div>
+<p id="brand" itemprop="brand">ACME</p>
+<div vocab="https://schema.org/" typeof="Person">
+  <span property="name">Jane</span>
+  <a property="url" href="/jane">home</a>
+  <div property="address" typeof="PostalAddress"><span property="addressLocality">Paris</span></div>
+</div>
+</body></html>`))
+	data := doc.StructuredData()
+	if data.OpenGraph.Get("og:title") != "Widget" || len(data.OpenGraph["og:image"]) != 2 || data.Twitter.Get("twitter:card") != "summary" {
+		t.Errorf("meta = %v %v", data.OpenGraph, data.Twitter)
+	}
+	if len(data.JSONLD) != 2 || data.JSONLD[0]["name"] != "ACME" || data.JSONLD[1]["@context"] != "https://schema.org" {
+		t.Errorf("JSONLD = %v", data.JSONLD)
+	}
+	if len(data.Microdata) != 1 {
+		t.Fatalf("Microdata = %v", data.Microdata)
+	}
+	product := data.Microdata[0]
+	offer, _ := product["offers"].(StructuredItem)
+	if product["@type"] != "Product" || product["name"] != "Widget" || product["image"] != "/w.png" || product["brand"] != "ACME" ||
+		offer["price"] != "9.99" || offer["priceCurrency"] != "USD" {
+		t.Errorf("Microdata = %v", product)
+	}
+	if colors, _ := product["color"].([]interface{}); len(colors) != 2 {
+		t.Errorf("multi-valued property = %v", product["color"])
+	}
+	if len(data.RDFa) != 1 {
+		t.Fatalf("RDFa = %v", data.RDFa)
+	}
+	person := data.RDFa[0]
+	address, _ := person["address"].(StructuredItem)
+	if person["@type"] != "Person" || person["name"] != "Jane" || person["url"] != "/jane" || address["addressLocality"] != "Paris" {
+		t.Errorf("RDFa = %v", person)
+	}
+	if len(data.ItemsOfType("https://schema.org/Product")) != 1 || len(data.Items()) != 4 {
+		t.Errorf("ItemsOfType/Items")
+	}
+
+	// itemref指向自身或祖先的循环引用不应无限递归
+	cyclic := NewSelector([]byte(`<div itemscope><div id="s" itemprop="x" itemscope itemref="s">hi</div></div>`)).StructuredData()
+	if len(cyclic.Microdata) != 1 {
+		t.Fatalf("cyclic microdata = %v", cyclic.Microdata)
+	}
+	if x, ok := cyclic.Microdata[0]["x"].(StructuredItem); !ok || len(x) != 0 {
+		t.Errorf("cyclic item = %v", cyclic.Microdata[0])
+	}
+}
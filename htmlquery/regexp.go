@@ -0,0 +1,167 @@
+package htmlquery
+
+import (
+	"log"
+	"regexp"
+	"sync"
+
+	"github.com/golang/groupcache/lru"
+)
+
+// invalidPatterns 已输出过编译错误的正则表达式, 每个只输出一次
+var invalidPatterns sync.Map
+
+// getRegexp 与getQuery共用缓存, 以前缀区分正则表达式
+func getRegexp(pattern string) (*regexp.Regexp, error) {
+	if DisableSelectorCache || SelectorCacheMaxEntries <= 0 {
+		return regexp.Compile(pattern)
+	}
+	cacheOnce.Do(func() {
+		cache = lru.New(SelectorCacheMaxEntries)
+	})
+	key := "re:" + pattern
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	if v, ok := cache.Get(key); ok {
+		return v.(*regexp.Regexp), nil
+	}
+	v, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	cache.Add(key, v)
+	return v, nil
+}
+
+// mustRegexp 编译正则表达式, 无效时StrictMode下panic, 否则输出一次错误并返回nil
+//
+// See `TryRe()` function.
+func mustRegexp(pattern string) *regexp.Regexp {
+	re, err := getRegexp(pattern)
+	if err != nil {
+		if StrictMode {
+			panic(err)
+		}
+		if _, logged := invalidPatterns.LoadOrStore(pattern, true); !logged {
+			log.Printf("invalid regexp %q: %v", pattern, err)
+		}
+	}
+	return re
+}
+
+// reAll 无分组时返回所有完整匹配, 有分组时依次返回每次匹配的所有分组
+func reAll(re *regexp.Regexp, text string) []string {
+	result := []string{}
+	if re == nil {
+		return result
+	}
+	for _, match := range re.FindAllStringSubmatch(text, -1) {
+		if len(match) == 1 {
+			result = append(result, match[0])
+		} else {
+			result = append(result, match[1:]...)
+		}
+	}
+	return result
+}
+
+func reGroups(re *regexp.Regexp, match []string) map[string]string {
+	groups := make(map[string]string)
+	for i, name := range re.SubexpNames() {
+		if name != "" {
+			groups[name] = match[i]
+		}
+	}
+	return groups
+}
+
+// Re 对Get()的结果执行正则匹配, 无分组时返回所有完整匹配, 有分组时返回所有分组; 正则表达式无效时返回空结果
+//
+// See `TryRe()` function.
+func (s *Selector) Re(pattern string) []string {
+	return reAll(mustRegexp(pattern), s.Get())
+}
+
+// TryRe 与Re相同, 正则表达式无效时返回错误
+func (s *Selector) TryRe(pattern string) ([]string, error) {
+	re, err := getRegexp(pattern)
+	if err != nil {
+		return []string{}, err
+	}
+	return reAll(re, s.Get()), nil
+}
+
+// ReFirst 返回Re的第一个结果, 无匹配时返回defaultValue
+func (s *Selector) ReFirst(pattern string, defaultValue string) string {
+	if result := s.Re(pattern); len(result) > 0 {
+		return result[0]
+	}
+	return defaultValue
+}
+
+// ReGroups 返回第一次匹配的命名分组, 无匹配时返回nil
+func (s *Selector) ReGroups(pattern string) map[string]string {
+	re := mustRegexp(pattern)
+	if re == nil {
+		return nil
+	}
+	match := re.FindStringSubmatch(s.Get())
+	if match == nil {
+		return nil
+	}
+	return reGroups(re, match)
+}
+
+// ReAllGroups 返回所有匹配的命名分组
+func (s *Selector) ReAllGroups(pattern string) []map[string]string {
+	result := []map[string]string{}
+	re := mustRegexp(pattern)
+	if re == nil {
+		return result
+	}
+	for _, match := range re.FindAllStringSubmatch(s.Get(), -1) {
+		result = append(result, reGroups(re, match))
+	}
+	return result
+}
+
+// Re 所有Selector的Re结果
+func (ss Selectors) Re(pattern string) []string {
+	result := []string{}
+	for _, s := range ss {
+		result = append(result, s.Re(pattern)...)
+	}
+	return result
+}
+
+// TryRe 所有Selector的TryRe结果, 正则表达式无效时返回错误
+func (ss Selectors) TryRe(pattern string) ([]string, error) {
+	re, err := getRegexp(pattern)
+	if err != nil {
+		return []string{}, err
+	}
+	result := []string{}
+	for _, s := range ss {
+		result = append(result, reAll(re, s.Get())...)
+	}
+	return result, nil
+}
+
+// ReFirst 返回Re的第一个结果, 无匹配时返回defaultValue
+func (ss Selectors) ReFirst(pattern string, defaultValue string) string {
+	for _, s := range ss {
+		if result := s.Re(pattern); len(result) > 0 {
+			return result[0]
+		}
+	}
+	return defaultValue
+}
+
+// ReGroups 所有Selector中匹配的命名分组
+func (ss Selectors) ReGroups(pattern string) []map[string]string {
+	result := []map[string]string{}
+	for _, s := range ss {
+		result = append(result, s.ReAllGroups(pattern)...)
+	}
+	return result
+}
@@ -3,6 +3,7 @@ package htmlquery
 import (
 	"bytes"
 	"fmt"
+	"strings"
 	"sync"
 
 	"github.com/antchfx/xpath"
@@ -14,6 +15,8 @@ import (
 type Selector struct {
 	Node   *html.Node
 	IsRoot bool
+	// IsAttr 是否为::attr()或Xpath选中的属性, 此时Node为以属性名为Data、属性值为文本的虚拟节点
+	IsAttr bool
 }
 
 // Selectors Selector数组
@@ -60,33 +63,59 @@ func getQueryByCSS(css string, global bool) (*xpath.Expr, error) {
 	if global {
 		scope = GLOBAL
 	}
+	return getQueryByScope(css, Scope(scope))
+}
 
+// getQueryByScope 按作用域转换css并编译, 结果缓存
+func getQueryByScope(css string, scope Scope) (*xpath.Expr, error) {
 	if DisableSelectorCache || SelectorCacheMaxEntries <= 0 {
-
-		return getQuery(CSS2Xpath(css, Scope(scope)))
+		expr, err := TryCSS2Xpath(css, Scope(scope))
+		if err != nil {
+			return nil, err
+		}
+		return getQuery(expr)
 	}
 
 	cacheOnce.Do(func() {
 		cache = lru.New(SelectorCacheMaxEntries)
 	})
+	// css与xpath共用缓存, 以前缀区分作用域
+	key := fmt.Sprintf("css:%d:%s", scope, css)
 	cacheMutex.Lock()
 	// defer
 
-	if v, ok := cache.Get(css); ok {
+	if v, ok := cache.Get(key); ok {
 		cacheMutex.Unlock()
 		return v.(*xpath.Expr), nil
 	}
 	cacheMutex.Unlock()
-	v, err := getQuery(CSS2Xpath(css, Scope(scope)))
+	expr, err := TryCSS2Xpath(css, Scope(scope))
+	if err != nil {
+		return nil, err
+	}
+	v, err := getQuery(expr)
 	if err != nil {
 		return nil, err
 	}
-	cache.Add(css, v)
+	cacheMutex.Lock()
+	cache.Add(key, v)
+	cacheMutex.Unlock()
 	return v, nil
 }
 
-// NewSelector 通过bytes 生成selector
+// StrictMode 为true时CSS和Xpath在选择器无效时panic, 而不是返回空结果
+var StrictMode = false
+
+// NewSelector 通过bytes 生成selector, 解析失败时返回nil
+//
+// See `ParseSelector()` function.
 func NewSelector(content []byte) *Selector {
+	selector, _ := ParseSelector(content)
+	return selector
+}
+
+// ParseSelector 通过bytes 生成selector, 返回解析错误
+func ParseSelector(content []byte) (*Selector, error) {
 	// r, err := charset.(resp.Body, resp.Header.Get("Content-Type"))
 	// if err != nil {
 	// 	return nil, err
@@ -94,46 +123,119 @@ func NewSelector(content []byte) *Selector {
 
 	node, err := html.Parse(bytes.NewReader(content))
 	if err != nil {
-		fmt.Println(err)
-		return nil
+		return nil, err
 	}
-	return &Selector{node, true}
+	return &Selector{Node: node, IsRoot: true}, nil
 }
 
-// CSS 通过CSS选择节点
+// CSS 通过CSS选择节点, 选择器无效时返回空结果
+//
+// See `TryCSS()` function.
 func (s *Selector) CSS(css string) Selectors {
-	fmt.Println(css)
-	xpath, err := getQueryByCSS(css, s.IsRoot)
-	fmt.Println("css")
-	fmt.Println(CSS2Xpath(css, Scope(LOCAL)))
+	selectors, err := s.TryCSS(css)
+	if err != nil && StrictMode {
+		panic(err)
+	}
+	return selectors
+}
+
+// TryCSS 通过CSS选择节点, 选择器无效时返回*SelectorError
+func (s *Selector) TryCSS(css string) (Selectors, error) {
+	xpath, err := getQueryByCSS(css, s.Exists() && s.IsRoot)
 	if err != nil {
-		return Selectors{}
+		return Selectors{}, err
 	}
-	nodes := QuerySelectorAll(s.Node, xpath)
-	selectors := make(Selectors, len(nodes))
-	for i, node := range nodes {
-		selectors[i] = &Selector{node, false}
+	if !s.Exists() {
+		return Selectors{}, nil
 	}
+	return trySelectAll(s.Node, xpath, css)
+}
 
+// Xpath 通过Xpath选择节点, 表达式无效时返回空结果
+//
+// See `TryXpath()` function.
+func (s *Selector) Xpath(path string) Selectors {
+	selectors, err := s.TryXpath(path)
+	if err != nil && StrictMode {
+		panic(err)
+	}
 	return selectors
 }
 
-// Xpath 通过Xpath选择节点
-func (s *Selector) Xpath(path string) Selectors {
+// TryXpath 通过Xpath选择节点, 表达式无效时返回*SelectorError
+func (s *Selector) TryXpath(path string) (Selectors, error) {
 	xpath, err := getQuery(path)
 	if err != nil {
-		return Selectors{}
+		return Selectors{}, &SelectorError{Selector: path, Offset: xpathErrorOffset(path), Msg: err.Error()}
+	}
+	if !s.Exists() {
+		return Selectors{}, nil
+	}
+	return trySelectAll(s.Node, xpath, path)
+}
+
+// trySelectAll 与selectAll相同, 求值时的panic转为*SelectorError, selector为原始的选择器
+func trySelectAll(top *html.Node, expr *xpath.Expr, selector string) (selectors Selectors, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			selectors, err = Selectors{}, &SelectorError{Selector: selector, Offset: -1, Msg: fmt.Sprintf("evaluation failed: %v", r)}
+		}
+	}()
+	return selectAll(top, expr), nil
+}
+
+// xpathErrorOffset 估计Xpath表达式的出错位置: 未匹配的右括号、未结束的字符串的起始位置, 否则为表达式末尾
+func xpathErrorOffset(expr string) int {
+	var stack []byte
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; c {
+		case '"', '\'':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end < 0 {
+				return i
+			}
+			i += end + 1
+		case '(', '[':
+			stack = append(stack, c)
+		case ')', ']':
+			open := byte('(')
+			if c == ']' {
+				open = '['
+			}
+			if len(stack) == 0 || stack[len(stack)-1] != open {
+				return i
+			}
+			stack = stack[:len(stack)-1]
+		}
 	}
-	nodes := QuerySelectorAll(s.Node, xpath)
-	selectors := make(Selectors, len(nodes))
-	for i, node := range nodes {
-		selectors[i] = &Selector{node, false}
+	return len(expr)
+}
+
+// selectAll 与QuerySelectorAll相同, 但保留重复的属性值并标记属性节点
+func selectAll(top *html.Node, selector *xpath.Expr) Selectors {
+	selectors := Selectors{}
+	t := selector.Select(CreateXPathNavigator(top))
+	for t.MoveNext() {
+		nav := t.Current().(*NodeNavigator)
+		n := getCurrentNode(nav)
+		if len(selectors) > 0 && selectors[0].Node == n {
+			continue
+		}
+		selectors = append(selectors, &Selector{Node: n, IsAttr: nav.NodeType() == xpath.AttributeNode})
 	}
 	return selectors
 }
 
+// Exists 是否选中了节点, nil和First()返回的空Selector为false
+func (s *Selector) Exists() bool {
+	return s != nil && s.Node != nil
+}
+
 // Attr 获取节点属性
 func (s *Selector) Attr(name string) string {
+	if !s.Exists() {
+		return ""
+	}
 	for _, attr := range s.Node.Attr {
 		if attr.Key == name {
 			return attr.Val
@@ -144,6 +246,9 @@ func (s *Selector) Attr(name string) string {
 
 // Text 获取节点内所有文本
 func (s *Selector) Text() string {
+	if !s.Exists() {
+		return ""
+	}
 	var output func(*bytes.Buffer, *html.Node)
 	output = func(buf *bytes.Buffer, n *html.Node) {
 		switch n.Type {
@@ -165,21 +270,77 @@ func (s *Selector) Text() string {
 
 // HTML 获取节点完整html代码
 func (s *Selector) HTML() string {
+	if !s.Exists() {
+		return ""
+	}
 	var buf bytes.Buffer
 	html.Render(&buf, s.Node)
 	return buf.String()
 }
 
+// Get 属性和文本节点返回其文本, 元素节点返回完整html代码
+func (s *Selector) Get() string {
+	if !s.Exists() {
+		return ""
+	}
+	if s.IsAttr || s.Node.Type == html.TextNode {
+		return s.Text()
+	}
+	return s.HTML()
+}
+
 // InnerHTML 获取节点内html代码
 func (s *Selector) InnerHTML() string {
+	if !s.Exists() {
+		return ""
+	}
 	var buf bytes.Buffer
 
-	for n := s.Node.FirstChild; n != nil; n = s.Node.NextSibling {
+	for n := s.Node.FirstChild; n != nil; n = n.NextSibling {
 		html.Render(&buf, n)
 	}
 	return buf.String()
 }
 
+// First 第一个Selector, 结果为空时返回不包含节点的空Selector, 其方法均返回空值
+func (ss Selectors) First() *Selector {
+	if len(ss) == 0 {
+		return &Selector{}
+	}
+	return ss[0]
+}
+
+// Get 第一个Selector的Get结果, 结果为空时返回""
+func (ss Selectors) Get() string {
+	return ss.First().Get()
+}
+
+// GetOr 第一个Selector的Get结果, 结果为空时返回defaultValue
+func (ss Selectors) GetOr(defaultValue string) string {
+	if len(ss) == 0 {
+		return defaultValue
+	}
+	return ss[0].Get()
+}
+
+// CSS 对每个Selector执行CSS, 合并结果
+func (ss Selectors) CSS(css string) Selectors {
+	result := Selectors{}
+	for _, s := range ss {
+		result = append(result, s.CSS(css)...)
+	}
+	return result
+}
+
+// Xpath 对每个Selector执行Xpath, 合并结果
+func (ss Selectors) Xpath(path string) Selectors {
+	result := Selectors{}
+	for _, s := range ss {
+		result = append(result, s.Xpath(path)...)
+	}
+	return result
+}
+
 // Texts 所有Selector的text列表
 func (ss Selectors) Texts() []string {
 	texts := make([]string, len(ss))
@@ -189,6 +350,15 @@ func (ss Selectors) Texts() []string {
 	return texts
 }
 
+// Getall 所有Selector的Get结果
+func (ss Selectors) Getall() []string {
+	result := make([]string, len(ss))
+	for i, s := range ss {
+		result[i] = s.Get()
+	}
+	return result
+}
+
 // HTMLs 获取节点完整html代码
 func (ss Selectors) HTMLs() []string {
 	texts := make([]string, len(ss))
@@ -0,0 +1,352 @@
+package htmlquery
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// StructuredItem 一个结构化数据实体, 与JSON-LD形式相同:
+// "@type"、"@id"、"@context"为类型、标识和词汇表, 其余为属性;
+// 属性值为string、StructuredItem(嵌套实体)或多值时的[]interface{}
+type StructuredItem = map[string]interface{}
+
+// MetaProperties meta标签中的属性, 同名属性保留所有值
+type MetaProperties map[string][]string
+
+// Get 属性的第一个值, 不存在时返回""
+func (m MetaProperties) Get(name string) string {
+	if values := m[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// StructuredData 页面中的结构化数据
+type StructuredData struct {
+	// JSONLD <script type="application/ld+json">中的实体, 数组和@graph会展开
+	JSONLD []StructuredItem
+	// Microdata itemscope/itemprop标记的顶层实体
+	Microdata []StructuredItem
+	// RDFa RDFa Lite(vocab/typeof/property)标记的顶层实体
+	RDFa []StructuredItem
+	// OpenGraph og:、article:、product:等meta property
+	OpenGraph MetaProperties
+	// Twitter twitter:开头的meta
+	Twitter MetaProperties
+}
+
+// Items JSON-LD、Microdata和RDFa中的所有实体
+func (d *StructuredData) Items() []StructuredItem {
+	items := make([]StructuredItem, 0, len(d.JSONLD)+len(d.Microdata)+len(d.RDFa))
+	items = append(items, d.JSONLD...)
+	items = append(items, d.Microdata...)
+	return append(items, d.RDFa...)
+}
+
+// ItemsOfType 指定类型的实体, 如"Product"; 类型可带词汇表前缀, 如"https://schema.org/Product"
+func (d *StructuredData) ItemsOfType(itemType string) []StructuredItem {
+	itemType = shortType(itemType)
+	items := []StructuredItem{}
+	for _, item := range d.Items() {
+		switch t := item["@type"].(type) {
+		case string:
+			if shortType(t) == itemType {
+				items = append(items, item)
+			}
+		case []interface{}:
+			for _, v := range t {
+				if s, ok := v.(string); ok && shortType(s) == itemType {
+					items = append(items, item)
+					break
+				}
+			}
+		}
+	}
+	return items
+}
+
+// shortType 去掉类型的词汇表前缀
+func shortType(t string) string {
+	if i := strings.LastIndexAny(t, "/#:"); i >= 0 {
+		return t[i+1:]
+	}
+	return t
+}
+
+// StructuredData 提取节点内的结构化数据
+func (s *Selector) StructuredData() *StructuredData {
+	data := &StructuredData{
+		JSONLD:    []StructuredItem{},
+		Microdata: []StructuredItem{},
+		RDFa:      []StructuredItem{},
+		OpenGraph: MetaProperties{},
+		Twitter:   MetaProperties{},
+	}
+	if !s.Exists() || s.IsAttr {
+		return data
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch {
+			case n.Data == "script":
+				if strings.EqualFold(strings.TrimSpace(SelectAttr(n, "type")), "application/ld+json") {
+					data.JSONLD = append(data.JSONLD, parseJSONLD(nodeText(n))...)
+				}
+				return
+			case n.Data == "meta":
+				collectMeta(data, n)
+			}
+			if hasAttr(n, "itemscope") && !hasAttr(n, "itemprop") {
+				data.Microdata = append(data.Microdata, microdata.item(n, s.rootNode(), nil))
+			}
+			if hasAttr(n, "typeof") && !hasAttr(n, "property") {
+				data.RDFa = append(data.RDFa, rdfa.item(n, nil, nil))
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(s.Node)
+	return data
+}
+
+func (s *Selector) rootNode() *html.Node {
+	n := s.Node
+	for n.Parent != nil {
+		n = n.Parent
+	}
+	return n
+}
+
+func collectMeta(data *StructuredData, n *html.Node) {
+	content := SelectAttr(n, "content")
+	for _, key := range []string{"property", "name"} {
+		for _, name := range strings.Fields(SelectAttr(n, key)) {
+			switch {
+			case strings.HasPrefix(name, "twitter:"):
+				data.Twitter[name] = append(data.Twitter[name], content)
+			case key == "property" && strings.Contains(name, ":"):
+				data.OpenGraph[name] = append(data.OpenGraph[name], content)
+			}
+		}
+	}
+}
+
+// parseJSONLD 解析JSON-LD, 忽略无效内容, 展开数组和@graph
+func parseJSONLD(content string) []StructuredItem {
+	content = strings.TrimSpace(content)
+	for _, wrap := range [][2]string{{"<!--", "-->"}, {"<![CDATA[", "]]>"}} {
+		content = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(content, wrap[0]), wrap[1]))
+	}
+	content = strings.TrimRight(content, "; \t\r\n")
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(content)))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil
+	}
+	var items []StructuredItem
+	var flatten func(v interface{}, context interface{})
+	flatten = func(v interface{}, context interface{}) {
+		switch v := v.(type) {
+		case []interface{}:
+			for _, e := range v {
+				flatten(e, context)
+			}
+		case map[string]interface{}:
+			if c, ok := v["@context"]; ok {
+				context = c
+			}
+			if graph, ok := v["@graph"]; ok {
+				flatten(graph, context)
+				return
+			}
+			if _, ok := v["@context"]; !ok && context != nil {
+				v["@context"] = context
+			}
+			items = append(items, v)
+		}
+	}
+	flatten(value, nil)
+	return items
+}
+
+// itemSyntax Microdata和RDFa Lite的属性名
+type itemSyntax struct {
+	scope, prop, itemType, id string
+}
+
+var (
+	microdata = itemSyntax{scope: "itemscope", prop: "itemprop", itemType: "itemtype", id: "itemid"}
+	rdfa      = itemSyntax{scope: "typeof", prop: "property", itemType: "typeof", id: "resource"}
+)
+
+// item 将带scope属性的节点转为实体; Microdata时root用于查找itemref引用的节点,
+// visiting为正在展开的实体, 用于跳过itemref形成的循环引用
+func (syntax itemSyntax) item(n *html.Node, root *html.Node, visiting map[*html.Node]bool) StructuredItem {
+	if visiting == nil {
+		visiting = map[*html.Node]bool{}
+	}
+	visiting[n] = true
+	defer delete(visiting, n)
+	item := StructuredItem{}
+	if syntax == rdfa {
+		if vocab := inheritedAttr(n, "vocab"); vocab != "" {
+			item["@context"] = vocab
+		}
+	}
+	var types []interface{}
+	for _, t := range strings.Fields(SelectAttr(n, syntax.itemType)) {
+		if syntax == microdata {
+			if i := strings.LastIndexAny(t, "/#"); i >= 0 {
+				item["@context"] = t[:i]
+				t = t[i+1:]
+			}
+		}
+		types = append(types, t)
+	}
+	switch len(types) {
+	case 0:
+	case 1:
+		item["@type"] = types[0]
+	default:
+		item["@type"] = types
+	}
+	if id := SelectAttr(n, syntax.id); id != "" {
+		item["@id"] = id
+	}
+
+	// addProperties 添加节点上的属性, 跳过正在展开的实体
+	addProperties := func(n *html.Node) {
+		names := strings.Fields(SelectAttr(n, syntax.prop))
+		if len(names) == 0 || visiting[n] {
+			return
+		}
+		value := syntax.value(n, root, visiting)
+		for _, name := range names {
+			addProperty(item, name, value)
+		}
+	}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			addProperties(child)
+			// 嵌套实体内的属性属于嵌套实体
+			if !hasAttr(child, syntax.scope) {
+				walk(child)
+			}
+		}
+	}
+	walk(n)
+	if syntax == microdata && root != nil {
+		expanded := map[*html.Node]bool{}
+		for _, ref := range strings.Fields(SelectAttr(n, "itemref")) {
+			if target := findByID(root, ref); target != nil && !expanded[target] {
+				expanded[target] = true
+				addProperties(target)
+				if !hasAttr(target, syntax.scope) {
+					walk(target)
+				}
+			}
+		}
+	}
+	return item
+}
+
+// value 属性值: 嵌套实体, 或按元素类型取对应的属性, 否则取文本
+func (syntax itemSyntax) value(n *html.Node, root *html.Node, visiting map[*html.Node]bool) interface{} {
+	if hasAttr(n, syntax.scope) {
+		return syntax.item(n, root, visiting)
+	}
+	if syntax == rdfa {
+		for _, key := range []string{"content", "resource", "href", "src"} {
+			if hasAttr(n, key) {
+				return SelectAttr(n, key)
+			}
+		}
+	}
+	switch n.Data {
+	case "meta":
+		return SelectAttr(n, "content")
+	case "audio", "embed", "iframe", "img", "source", "track", "video":
+		return SelectAttr(n, "src")
+	case "a", "area", "link":
+		return SelectAttr(n, "href")
+	case "object":
+		return SelectAttr(n, "data")
+	case "data", "meter":
+		return SelectAttr(n, "value")
+	case "time":
+		if hasAttr(n, "datetime") {
+			return SelectAttr(n, "datetime")
+		}
+	}
+	if syntax == microdata && hasAttr(n, "content") {
+		return SelectAttr(n, "content")
+	}
+	return (&Selector{Node: n}).NormalizedText()
+}
+
+// addProperty 添加属性, 同名属性合并为数组
+func addProperty(item StructuredItem, name string, value interface{}) {
+	old, ok := item[name]
+	if !ok {
+		item[name] = value
+		return
+	}
+	if values, ok := old.([]interface{}); ok {
+		item[name] = append(values, value)
+		return
+	}
+	item[name] = []interface{}{old, value}
+}
+
+func hasAttr(n *html.Node, name string) bool {
+	for _, attr := range n.Attr {
+		if attr.Key == name {
+			return true
+		}
+	}
+	return false
+}
+
+func inheritedAttr(n *html.Node, name string) string {
+	for ; n != nil; n = n.Parent {
+		if n.Type == html.ElementNode && hasAttr(n, name) {
+			return SelectAttr(n, name)
+		}
+	}
+	return ""
+}
+
+func nodeText(n *html.Node) string {
+	var buf strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.TextNode {
+			buf.WriteString(child.Data)
+		}
+	}
+	return buf.String()
+}
+
+func findByID(n *html.Node, id string) *html.Node {
+	if n.Type == html.ElementNode && SelectAttr(n, "id") == id {
+		return n
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if found := findByID(child, id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
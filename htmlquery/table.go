@@ -0,0 +1,289 @@
+package htmlquery
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// maxTableSpan rowspan和colspan的上限, 避免异常值生成过大的表格
+const maxTableSpan = 1000
+
+// TableOptions 表格提取选项
+type TableOptions struct {
+	// CellHTML 单元格取内部html代码, 默认取合并空白后的文本
+	CellHTML bool
+	// Header 指定表头, 此时表格中所有行都作为数据行
+	Header []string
+	// NoHeader 不识别表头, 所有行都作为数据行, 列名为从0开始的序号
+	NoHeader bool
+}
+
+// Table 提取出的表格, rowspan和colspan已展开, 每行长度相同
+type Table struct {
+	Header []string
+	Rows   [][]string
+}
+
+// Records 以表头为键的记录, 可直接作为item提交
+func (t *Table) Records() []map[string]string {
+	keys := tableKeys(t.Header, t.width())
+	records := make([]map[string]string, len(t.Rows))
+	for i, row := range t.Rows {
+		record := make(map[string]string, len(keys))
+		for j, key := range keys {
+			if j < len(row) {
+				record[key] = row[j]
+			} else {
+				record[key] = ""
+			}
+		}
+		records[i] = record
+	}
+	return records
+}
+
+func (t *Table) width() int {
+	width := len(t.Header)
+	for _, row := range t.Rows {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	return width
+}
+
+// tableKeys 空列名用序号代替, 重复列名添加_2、_3后缀
+func tableKeys(header []string, width int) []string {
+	keys := make([]string, width)
+	seen := make(map[string]int)
+	for i := range keys {
+		key := ""
+		if i < len(header) {
+			key = header[i]
+		}
+		if key == "" {
+			key = strconv.Itoa(i)
+		}
+		seen[key]++
+		if n := seen[key]; n > 1 {
+			key += "_" + strconv.Itoa(n)
+		}
+		keys[i] = key
+	}
+	return keys
+}
+
+// Table 以默认选项提取表格
+//
+// See `TableWith()` function.
+func (s *Selector) Table() *Table {
+	return s.TableWith(TableOptions{})
+}
+
+// TableWith 提取表格, 节点不是table时使用其中第一个table;
+// 有thead时thead中的行为表头, 否则开头全部由th组成的行为表头, 多行表头按列以空格连接
+func (s *Selector) TableWith(options TableOptions) *Table {
+	table := &Table{Header: []string{}, Rows: [][]string{}}
+	if !s.Exists() || s.IsAttr {
+		return table
+	}
+	node := findTable(s.Node)
+	if node == nil {
+		return table
+	}
+
+	rows, headerRows := tableRows(node)
+	grid := expandTable(rows, options.CellHTML)
+	width := 0
+	for _, row := range grid {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	for i, row := range grid {
+		for len(row) < width {
+			row = append(row, "")
+		}
+		grid[i] = row
+	}
+
+	switch {
+	case options.Header != nil:
+		table.Header = options.Header
+	case options.NoHeader:
+		table.Header = tableKeys(nil, width)
+	default:
+		if headerRows == 0 {
+			for headerRows < len(rows) && isHeaderRow(rows[headerRows]) {
+				headerRows++
+			}
+		}
+		table.Header = joinHeaderRows(grid[:headerRows], width)
+		grid = grid[headerRows:]
+	}
+	table.Rows = grid
+	return table
+}
+
+// Tables 每个Selector提取出的表格
+func (ss Selectors) Tables() []*Table {
+	tables := make([]*Table, len(ss))
+	for i, s := range ss {
+		tables[i] = s.Table()
+	}
+	return tables
+}
+
+func findTable(n *html.Node) *html.Node {
+	if n.Type == html.ElementNode && n.Data == "table" {
+		return n
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if table := findTable(child); table != nil {
+			return table
+		}
+	}
+	return nil
+}
+
+// tableRows 表格自身的所有行(不包括嵌套表格), thead中的行排在最前, 同时返回thead中的行数
+func tableRows(table *html.Node) ([]*html.Node, int) {
+	var head, body []*html.Node
+	for child := table.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode {
+			continue
+		}
+		switch child.Data {
+		case "tr":
+			body = append(body, child)
+		case "thead", "tbody", "tfoot":
+			for tr := child.FirstChild; tr != nil; tr = tr.NextSibling {
+				if tr.Type != html.ElementNode || tr.Data != "tr" {
+					continue
+				}
+				if child.Data == "thead" {
+					head = append(head, tr)
+				} else {
+					body = append(body, tr)
+				}
+			}
+		}
+	}
+	return append(head, body...), len(head)
+}
+
+func tableCells(tr *html.Node) []*html.Node {
+	var cells []*html.Node
+	for cell := tr.FirstChild; cell != nil; cell = cell.NextSibling {
+		if cell.Type == html.ElementNode && (cell.Data == "td" || cell.Data == "th") {
+			cells = append(cells, cell)
+		}
+	}
+	return cells
+}
+
+func isHeaderRow(tr *html.Node) bool {
+	cells := tableCells(tr)
+	for _, cell := range cells {
+		if cell.Data != "th" {
+			return false
+		}
+	}
+	return len(cells) > 0
+}
+
+// cellSpan 单元格的rowspan或colspan, rowspan="0"时返回0, 表示延伸到所在行组的末尾
+func cellSpan(cell *html.Node, name string) int {
+	s := &Selector{Node: cell}
+	span, err := strconv.Atoi(strings.TrimSpace(s.Attr(name)))
+	if err == nil && span == 0 && name == "rowspan" {
+		return 0
+	}
+	if err != nil || span < 1 {
+		return 1
+	}
+	if span > maxTableSpan {
+		return maxTableSpan
+	}
+	return span
+}
+
+// expandTable 展开rowspan和colspan, 被合并的位置重复单元格的值;
+// 单元格跳过被上方rowspan占据的列, 不会覆盖它们
+func expandTable(rows []*html.Node, cellHTML bool) [][]string {
+	type pending struct {
+		remaining int
+		value     string
+	}
+	var spans []pending
+	grid := make([][]string, 0, len(rows))
+	for r, tr := range rows {
+		row := []string{}
+		col := 0
+		fill := func() {
+			for col < len(spans) && spans[col].remaining > 0 {
+				row = append(row, spans[col].value)
+				spans[col].remaining--
+				col++
+			}
+		}
+		for _, cell := range tableCells(tr) {
+			s := &Selector{Node: cell}
+			value := s.NormalizedText()
+			if cellHTML {
+				value = strings.TrimSpace(s.InnerHTML())
+			}
+			rowspan, colspan := cellSpan(cell, "rowspan"), cellSpan(cell, "colspan")
+			if rowspan == 0 {
+				rowspan = rowGroupRemaining(rows, r) + 1
+			}
+			for i := 0; i < colspan; i++ {
+				fill()
+				for len(spans) <= col {
+					spans = append(spans, pending{})
+				}
+				spans[col] = pending{remaining: rowspan - 1, value: value}
+				row = append(row, value)
+				col++
+			}
+		}
+		// 本行单元格之后仍被上方单元格占据的位置
+		for ; col < len(spans); col++ {
+			if spans[col].remaining > 0 {
+				row = append(row, spans[col].value)
+				spans[col].remaining--
+			} else {
+				row = append(row, "")
+			}
+		}
+		grid = append(grid, row)
+	}
+	return grid
+}
+
+// rowGroupRemaining 第r行之后同一行组(thead、tbody、tfoot)中的行数
+func rowGroupRemaining(rows []*html.Node, r int) int {
+	n := 0
+	for i := r + 1; i < len(rows) && rows[i].Parent == rows[r].Parent; i++ {
+		n++
+	}
+	return n
+}
+
+// joinHeaderRows 多行表头按列合并, 相同的值只保留一次
+func joinHeaderRows(rows [][]string, width int) []string {
+	header := make([]string, width)
+	for i := range header {
+		var parts []string
+		for _, row := range rows {
+			value := row[i]
+			if value != "" && (len(parts) == 0 || parts[len(parts)-1] != value) {
+				parts = append(parts, value)
+			}
+		}
+		header[i] = strings.Join(parts, " ")
+	}
+	return header
+}
@@ -0,0 +1,169 @@
+package htmlquery
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// TextOptions 可读文本的渲染选项
+type TextOptions struct {
+	// LineSeparator 行分隔符, 默认"\n"
+	LineSeparator string
+	// CellSeparator 表格单元格之间的分隔符, 默认" "
+	CellSeparator string
+	// SkipTags 跳过的元素, 为nil时使用默认的script、style、noscript、template, 不跳过任何元素时设为[]string{}
+	SkipTags []string
+	// KeepEmptyLines 是否保留连续<br>产生的空行
+	KeepEmptyLines bool
+}
+
+// DefaultTextOptions ReadableText使用的默认选项
+var DefaultTextOptions = TextOptions{
+	LineSeparator: "\n",
+	CellSeparator: " ",
+	SkipTags:      []string{"script", "style", "noscript", "template"},
+}
+
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "caption": true,
+	"dd": true, "details": true, "dialog": true, "div": true, "dl": true, "dt": true,
+	"fieldset": true, "figcaption": true, "figure": true, "footer": true, "form": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "hr": true, "li": true, "main": true, "nav": true, "ol": true,
+	"p": true, "pre": true, "section": true, "summary": true, "table": true,
+	"tbody": true, "tfoot": true, "thead": true, "tr": true, "ul": true,
+}
+
+const whitespace = " \t\n\r\f"
+
+// textWriter 按行收集文本, 合并行内空白
+type textWriter struct {
+	options TextOptions
+	lines   []string
+	line    strings.Builder
+	space   bool
+}
+
+func (w *textWriter) write(text string) {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		if text != "" {
+			w.space = true
+		}
+		return
+	}
+	if strings.TrimLeft(text, whitespace) != text {
+		w.space = true
+	}
+	for _, word := range words {
+		w.writeRaw(word)
+		w.space = true
+	}
+	w.space = strings.TrimRight(text, whitespace) != text
+}
+
+func (w *textWriter) writeRaw(text string) {
+	if w.space && w.line.Len() > 0 {
+		w.line.WriteString(" ")
+	}
+	w.line.WriteString(text)
+	w.space = false
+}
+
+func (w *textWriter) writeSeparator(sep string) {
+	if w.line.Len() > 0 {
+		w.line.WriteString(sep)
+	}
+	w.space = false
+}
+
+// breakLine 结束当前行, force为true时空行也会保留
+func (w *textWriter) breakLine(force bool) {
+	line := strings.TrimRight(w.line.String(), " \t")
+	if line != "" || (force && w.options.KeepEmptyLines) {
+		w.lines = append(w.lines, line)
+	}
+	w.line.Reset()
+	w.space = false
+}
+
+func (w *textWriter) render(n *html.Node, pre bool) {
+	switch n.Type {
+	case html.TextNode:
+		if pre {
+			for i, line := range strings.Split(n.Data, "\n") {
+				if i > 0 {
+					w.breakLine(true)
+				}
+				w.line.WriteString(line)
+			}
+		} else {
+			w.write(n.Data)
+		}
+		return
+	case html.CommentNode:
+		return
+	case html.ElementNode:
+		for _, tag := range w.options.SkipTags {
+			if n.Data == tag {
+				return
+			}
+		}
+		switch {
+		case n.Data == "br":
+			w.breakLine(true)
+			return
+		case n.Data == "td" || n.Data == "th":
+			w.writeSeparator(w.options.CellSeparator)
+		case blockElements[n.Data]:
+			w.breakLine(false)
+			defer w.breakLine(false)
+		}
+		if n.Data == "pre" {
+			pre = true
+		}
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		w.render(child, pre)
+	}
+}
+
+// ReadableText 以默认选项渲染可读文本
+//
+// See `RenderText()` function.
+func (s *Selector) ReadableText() string {
+	return s.RenderText(DefaultTextOptions)
+}
+
+// RenderText 渲染可读文本: 块级元素和<br>换行, 合并空白, 跳过script、style等元素, 保留<pre>中的格式
+func (s *Selector) RenderText(options TextOptions) string {
+	if !s.Exists() {
+		return ""
+	}
+	if s.IsAttr {
+		return strings.Join(strings.Fields(s.Text()), " ")
+	}
+	if options.LineSeparator == "" {
+		options.LineSeparator = "\n"
+	}
+	if options.CellSeparator == "" {
+		options.CellSeparator = " "
+	}
+	if options.SkipTags == nil {
+		options.SkipTags = DefaultTextOptions.SkipTags
+	}
+	w := &textWriter{options: options}
+	w.render(s.Node, false)
+	w.breakLine(false)
+	return strings.Join(w.lines, options.LineSeparator)
+}
+
+// ReadableTexts 所有Selector的ReadableText列表
+func (ss Selectors) ReadableTexts() []string {
+	texts := make([]string, len(ss))
+	for i, s := range ss {
+		texts[i] = s.ReadableText()
+	}
+	return texts
+}
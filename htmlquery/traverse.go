@@ -0,0 +1,134 @@
+package htmlquery
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// elementSelector 元素节点生成Selector, 否则返回空Selector
+func elementSelector(n *html.Node) *Selector {
+	if n == nil || n.Type != html.ElementNode {
+		return &Selector{}
+	}
+	return &Selector{Node: n}
+}
+
+// Parent 父元素, 不存在时返回空Selector
+func (s *Selector) Parent() *Selector {
+	if !s.Exists() || s.IsAttr {
+		return &Selector{}
+	}
+	return elementSelector(s.Node.Parent)
+}
+
+// Children 所有子元素, 不包括文本和注释节点
+func (s *Selector) Children() Selectors {
+	children := Selectors{}
+	if !s.Exists() || s.IsAttr {
+		return children
+	}
+	for n := s.Node.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type == html.ElementNode {
+			children = append(children, &Selector{Node: n})
+		}
+	}
+	return children
+}
+
+// NextSibling 下一个兄弟元素, 不存在时返回空Selector
+func (s *Selector) NextSibling() *Selector {
+	if !s.Exists() || s.IsAttr {
+		return &Selector{}
+	}
+	n := s.Node.NextSibling
+	for n != nil && n.Type != html.ElementNode {
+		n = n.NextSibling
+	}
+	return elementSelector(n)
+}
+
+// PrevSibling 上一个兄弟元素, 不存在时返回空Selector
+func (s *Selector) PrevSibling() *Selector {
+	if !s.Exists() || s.IsAttr {
+		return &Selector{}
+	}
+	n := s.Node.PrevSibling
+	for n != nil && n.Type != html.ElementNode {
+		n = n.PrevSibling
+	}
+	return elementSelector(n)
+}
+
+// Index 在兄弟元素中的位置, 从0开始, 空Selector返回-1
+func (s *Selector) Index() int {
+	if !s.Exists() || s.IsAttr {
+		return -1
+	}
+	index := 0
+	for n := s.Node.PrevSibling; n != nil; n = n.PrevSibling {
+		if n.Type == html.ElementNode {
+			index++
+		}
+	}
+	return index
+}
+
+// Closest 自身及祖先元素中第一个匹配css的元素, 不存在时返回空Selector
+func (s *Selector) Closest(css string) *Selector {
+	if !s.Exists() || s.IsAttr {
+		return &Selector{}
+	}
+	expr, err := getQueryByScope(css, MATCH)
+	if err != nil {
+		if StrictMode {
+			panic(err)
+		}
+		return &Selector{}
+	}
+	for n := s.Node; n != nil; n = n.Parent {
+		if n.Type == html.ElementNode && len(selectAll(n, expr)) > 0 {
+			return &Selector{Node: n}
+		}
+	}
+	return &Selector{}
+}
+
+// Attrs 节点的所有属性
+func (s *Selector) Attrs() map[string]string {
+	attrs := make(map[string]string)
+	if !s.Exists() || s.IsAttr {
+		return attrs
+	}
+	for _, attr := range s.Node.Attr {
+		attrs[attr.Key] = attr.Val
+	}
+	return attrs
+}
+
+// HasClass class属性中是否包含name
+func (s *Selector) HasClass(name string) bool {
+	if s == nil || s.IsAttr {
+		return false
+	}
+	for _, class := range strings.Fields(s.Attr("class")) {
+		if class == name {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizedText 单行的可读文本: 与ReadableText相同地跳过script、style等元素, 换行和连续空白合并为一个空格
+func (s *Selector) NormalizedText() string {
+	return strings.Join(strings.Fields(s.RenderText(TextOptions{LineSeparator: " "})), " ")
+}
+
+// NormalizedTexts 所有Selector的NormalizedText列表
+func (ss Selectors) NormalizedTexts() []string {
+	texts := make([]string, len(ss))
+	for i, s := range ss {
+		texts[i] = s.NormalizedText()
+	}
+	return texts
+}
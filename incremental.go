@@ -0,0 +1,207 @@
+package crawler
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// PageState 增量爬取时记录的页面状态
+type PageState struct {
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	ContentHash  string    `json:"content_hash,omitempty"`
+	CrawledAt    time.Time `json:"crawled_at"`
+}
+
+// IncrementalStore 增量爬取页面状态存储
+type IncrementalStore interface {
+	Load(url string) (*PageState, bool)
+	Save(url string, state *PageState) error
+}
+
+type memoryIncrementalStore struct {
+	states sync.Map
+}
+
+// NewMemoryIncrementalStore 创建内存中的页面状态存储, 仅在本次运行内有效
+func NewMemoryIncrementalStore() IncrementalStore {
+	return &memoryIncrementalStore{}
+}
+
+func (s *memoryIncrementalStore) Load(url string) (*PageState, bool) {
+	state, ok := s.states.Load(url)
+	if !ok {
+		return nil, false
+	}
+	return state.(*PageState), true
+}
+
+func (s *memoryIncrementalStore) Save(url string, state *PageState) error {
+	s.states.Store(url, state)
+	return nil
+}
+
+type pageStateRecord struct {
+	URL string `json:"url"`
+	*PageState
+}
+
+type fileIncrementalStore struct {
+	memoryIncrementalStore
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// NewFileIncrementalStore 创建基于文件的页面状态存储, 每行一条JSON记录, 多次运行之间保持状态
+func NewFileIncrementalStore(path string) (IncrementalStore, error) {
+	store := &fileIncrementalStore{}
+	records, err := readPageStateRecords(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		store.states.Store(record.URL, record.PageState)
+	}
+
+	// 启动时压缩文件, 同一url只保留最后一条记录
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	encoder := json.NewEncoder(file)
+	store.states.Range(func(url, state interface{}) bool {
+		err = encoder.Encode(&pageStateRecord{URL: url.(string), PageState: state.(*PageState)})
+		return err == nil
+	})
+	if err == nil {
+		err = file.Close()
+	} else {
+		file.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+
+	store.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func readPageStateRecords(path string) ([]*pageStateRecord, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []*pageStateRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		record := &pageStateRecord{}
+		// 忽略上次运行中断时写坏的行
+		if json.Unmarshal(scanner.Bytes(), record) == nil && record.URL != "" && record.PageState != nil {
+			records = append(records, record)
+		}
+	}
+	return records, scanner.Err()
+}
+
+func (s *fileIncrementalStore) Save(url string, state *PageState) error {
+	s.states.Store(url, state)
+	data, err := json.Marshal(&pageStateRecord{URL: url, PageState: state})
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err = s.file.Write(append(data, '\n'))
+	return err
+}
+
+// Close 关闭状态文件
+func (s *fileIncrementalStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file.Close()
+}
+
+func contentHash(body []byte) string {
+	sum := sha1.Sum(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// prepareConditionalRequest 为已爬取过的页面添加If-None-Match和If-Modified-Since请求头
+func (eng *CrawlEngine) prepareConditionalRequest(req *Request, request *http.Request) {
+	if eng.incrementalStore == nil || req.Method != http.MethodGet {
+		return
+	}
+	state, ok := eng.incrementalStore.Load(req.URL)
+	if !ok {
+		return
+	}
+	if request.Header == nil {
+		request.Header = http.Header{}
+	}
+	if state.ETag != "" && request.Header.Get("If-None-Match") == "" {
+		request.Header.Set("If-None-Match", state.ETag)
+	}
+	if state.LastModified != "" && request.Header.Get("If-Modified-Since") == "" {
+		request.Header.Set("If-Modified-Since", state.LastModified)
+	}
+}
+
+// checkUnchanged 判断页面自上次爬取以来是否未变化, 并更新页面状态
+func (eng *CrawlEngine) checkUnchanged(req *Request, res *Response) {
+	if eng.incrementalStore == nil || req.Method != http.MethodGet {
+		return
+	}
+	if res.StatusCode != http.StatusNotModified && (res.StatusCode < 200 || res.StatusCode >= 300) {
+		return
+	}
+
+	previous, ok := eng.incrementalStore.Load(req.URL)
+	state := &PageState{
+		ETag:         res.Headers.Get("ETag"),
+		LastModified: res.Headers.Get("Last-Modified"),
+		CrawledAt:    time.Now(),
+	}
+
+	if res.StatusCode == http.StatusNotModified {
+		res.Unchanged = true
+		if ok {
+			state.ContentHash = previous.ContentHash
+			if state.ETag == "" {
+				state.ETag = previous.ETag
+			}
+			if state.LastModified == "" {
+				state.LastModified = previous.LastModified
+			}
+		}
+	} else {
+		state.ContentHash = contentHash(res.Body)
+		res.Unchanged = ok && previous.ContentHash == state.ContentHash
+	}
+
+	if err := eng.incrementalStore.Save(req.URL, state); err != nil {
+		eng.Stats.Inc("incremental/save_failed_count")
+		log.Printf("save page state of %s failed: %v", req.URL, err)
+	}
+}
@@ -0,0 +1,63 @@
+package crawler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestIncrementalCrawl(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("<html><body>hello</body></html>"))
+	}))
+	defer server.Close()
+
+	store, err := NewFileIncrementalStore(filepath.Join(t.TempDir(), "pages.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var changed, unchanged int32
+	crawl := func() {
+		NewCrawler(&Settings{AutoParseHtml: true}).
+			WithIncrementalStore(store).
+			OnResponse(func(res *Response, ctx *Context) { atomic.AddInt32(&changed, 1) }).
+			OnUnchanged(func(res *Response, ctx *Context) { atomic.AddInt32(&unchanged, 1) }).
+			WithStartRequests(func(ctx *Context) []*Request { return GetURLs(server.URL) }).
+			Start(true)
+	}
+
+	crawl()
+	crawl()
+	if atomic.LoadInt32(&changed) != 1 || atomic.LoadInt32(&unchanged) != 1 {
+		t.Errorf("changed = %d, unchanged = %d, want 1 and 1", changed, unchanged)
+	}
+
+	state, ok := store.Load(server.URL)
+	if !ok || state.ETag != `"v1"` || state.ContentHash == "" {
+		t.Errorf("unexpected page state: %+v", state)
+	}
+}
+
+func TestIncrementalStoreClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pages.jsonl")
+	c := NewCrawler(&Settings{Incremental: true, IncrementalStorePath: path}).
+		WithStartRequests(func(ctx *Context) []*Request { return nil }).
+		Start(true)
+	if err := c.Engine.incrementalStore.Save("http://example.com/", &PageState{}); err == nil {
+		t.Error("incremental store opened from settings should be closed when the crawler stops")
+	}
+	var requestErrors int
+	c.OnRequestError(func(req *Request, err error, ctx *Context) { requestErrors++ })
+	c.Engine.checkUnchanged(GetURL("http://example.com/"), &Response{StatusCode: http.StatusOK, Headers: http.Header{}})
+	if c.Stats().Get("incremental/save_failed_count") != 1 || requestErrors != 0 {
+		t.Errorf("stats = %v, request errors = %d", c.Stats().Values(), requestErrors)
+	}
+}
@@ -0,0 +1,76 @@
+package crawler
+
+import (
+	"reflect"
+)
+
+// HandleItem 注册T类型item的处理函数, 按reflect.Type匹配;
+// T为结构体时同样处理*T类型的item, T为指针时同样处理其指向类型的item, 处理结果保持item原来的类型.
+// 返回DropItem创建的错误时丢弃item, 返回其他错误时记为处理失败
+func HandleItem[T any](c *Crawler, handler func(item T, ctx *Context) (T, error)) *Crawler {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if c.itemHandlers == nil {
+		c.itemHandlers = make(map[reflect.Type]ItemProcessFunc)
+	}
+	c.itemHandlers[t] = func(item interface{}, ctx *Context) (interface{}, error) {
+		if v, ok := item.(T); ok {
+			result, err := handler(v, ctx)
+			if err != nil {
+				return nil, err
+			}
+			return nonNilItem(result), nil
+		}
+		v := reflect.ValueOf(item)
+		if v.Kind() == reflect.Ptr {
+			// *T类型的item, 处理其指向的值并写回
+			if v.IsNil() {
+				return item, nil
+			}
+			result, err := handler(v.Elem().Interface().(T), ctx)
+			if err != nil {
+				return nil, err
+			}
+			v.Elem().Set(reflect.ValueOf(&result).Elem())
+			return item, nil
+		}
+		// T为指针, item为其指向类型的值
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		result, err := handler(p.Interface().(T), ctx)
+		if err != nil {
+			return nil, err
+		}
+		r := reflect.ValueOf(&result).Elem()
+		if r.IsNil() {
+			return nil, nil
+		}
+		return r.Elem().Interface(), nil
+	}
+	return c
+}
+
+// nonNilItem 处理函数返回的nil指针、map等视为未修改item
+func nonNilItem(item interface{}) interface{} {
+	v := reflect.ValueOf(item)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+	}
+	return item
+}
+
+// itemHandler HandleItem注册的处理函数, 先按类型精确匹配, 再匹配指针和其指向的类型
+func (c *Crawler) itemHandler(t reflect.Type) ItemProcessFunc {
+	if len(c.itemHandlers) == 0 || t == nil {
+		return nil
+	}
+	if handler := c.itemHandlers[t]; handler != nil {
+		return handler
+	}
+	if t.Kind() == reflect.Ptr {
+		return c.itemHandlers[t.Elem()]
+	}
+	return c.itemHandlers[reflect.PtrTo(t)]
+}
@@ -0,0 +1,200 @@
+package crawler
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// ItemField 结构体item的一个字段, 由crawler标签描述, 如`crawler:"title,css=h1"`、`crawler:"link,css=a,attr=href"`、`crawler:"title,css='h1, h2'"`
+type ItemField struct {
+	// Name 字段名, 依次取crawler标签、json标签中的名称和结构体字段名
+	Name string
+	// Index 用于reflect.Value.FieldByIndex的字段位置, 匿名结构体字段展开
+	Index []int
+	Type  reflect.Type
+	Tag   reflect.StructTag
+	// CSS、XPath 提取字段值的选择器, Attr 取值的属性, 为空时取文本
+	CSS   string
+	XPath string
+	Attr  string
+	// Options crawler标签中的其他选项, 不带值的选项为""
+	Options map[string]string
+}
+
+// crawlerTagOptions crawler标签支持的选项, 其他选项视为标签错误
+var crawlerTagOptions = map[string]bool{"css": true, "xpath": true, "attr": true, "required": true}
+
+// ItemSchema 结构体item的字段列表
+type ItemSchema struct {
+	Type   reflect.Type
+	Fields []ItemField
+	// Tagged 是否有字段使用了crawler标签, 导出和SQLPipeline按crawler标签命名字段
+	Tagged bool
+}
+
+// itemSchemaEntry 缓存的字段描述及crawler标签的错误
+type itemSchemaEntry struct {
+	schema *ItemSchema
+	err    error
+}
+
+var itemSchemas sync.Map
+
+// SchemaOf item的字段描述, item不是结构体或结构体指针时返回nil; crawler标签无效时返回错误
+func SchemaOf(item interface{}) (*ItemSchema, error) {
+	t := reflect.TypeOf(item)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, nil
+	}
+	if entry, ok := itemSchemas.Load(t); ok {
+		return entry.(*itemSchemaEntry).schema, entry.(*itemSchemaEntry).err
+	}
+	schema := &ItemSchema{Type: t}
+	entry := &itemSchemaEntry{schema: schema, err: schema.addFields(t, nil)}
+	if entry.err != nil {
+		entry.schema = nil
+	}
+	actual, _ := itemSchemas.LoadOrStore(t, entry)
+	return actual.(*itemSchemaEntry).schema, actual.(*itemSchemaEntry).err
+}
+
+func (s *ItemSchema) addFields(t reflect.Type, index []int) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		fieldIndex := append(append([]int(nil), index...), i)
+		tag, tagged := field.Tag.Lookup("crawler")
+		if tag == "-" {
+			continue
+		}
+		name, options, err := parseCrawlerTag(tag)
+		if err != nil {
+			return fmt.Errorf("invalid crawler tag of %s.%s: %w", t, field.Name, err)
+		}
+		if name == "" {
+			name = strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			}
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && name == "" && !tagged {
+			if err := s.addFields(field.Type, fieldIndex); err != nil {
+				return err
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		f := ItemField{Name: name, Index: fieldIndex, Type: field.Type, Tag: field.Tag, Options: map[string]string{}}
+		for _, option := range options {
+			key, value := option[0], option[1]
+			switch key {
+			case "css":
+				f.CSS = value
+			case "xpath":
+				f.XPath = value
+			case "attr":
+				f.Attr = value
+			default:
+				f.Options[key] = value
+			}
+		}
+		s.Tagged = s.Tagged || tagged
+		s.Fields = append(s.Fields, f)
+	}
+	return nil
+}
+
+// parseCrawlerTag 解析crawler标签, 返回名称和按顺序的选项
+//
+// 选项以逗号分隔, 括号和引号中的逗号不分隔; 值中的其他逗号需用单引号括起, 如css='h1, h2',
+// 只支持crawlerTagOptions中的选项, 因此未加引号的css=h1,h2会因h2不是选项而报错
+func parseCrawlerTag(tag string) (string, [][2]string, error) {
+	parts := splitTopLevel(tag)
+	var options [][2]string
+	for _, part := range parts[1:] {
+		key, value := strings.TrimSpace(part), ""
+		if i := strings.Index(key, "="); i >= 0 {
+			key, value = strings.TrimSpace(key[:i]), strings.TrimSpace(key[i+1:])
+		}
+		if !crawlerTagOptions[key] {
+			return "", nil, fmt.Errorf("unknown option %q, quote values containing commas", key)
+		}
+		if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		}
+		options = append(options, [2]string{key, value})
+	}
+	return strings.TrimSpace(parts[0]), options, nil
+}
+
+// splitTopLevel 按不在括号和引号中的逗号分隔
+func splitTopLevel(s string) []string {
+	var parts []string
+	var quote rune
+	depth, start := 0, 0
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '(' || r == '[':
+			depth++
+		case (r == ')' || r == ']') && depth > 0:
+			depth--
+		case r == ',' && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// Field 名为name的字段
+func (s *ItemSchema) Field(name string) (*ItemField, bool) {
+	for i := range s.Fields {
+		if s.Fields[i].Name == name {
+			return &s.Fields[i], true
+		}
+	}
+	return nil, false
+}
+
+// Names 按字段顺序的字段名
+func (s *ItemSchema) Names() []string {
+	names := make([]string, len(s.Fields))
+	for i, f := range s.Fields {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// Values 按字段顺序的字段值, item为nil指针时返回nil
+func (s *ItemSchema) Values(item interface{}) []reflect.Value {
+	v := reflect.ValueOf(item)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Type() != s.Type {
+		return nil
+	}
+	values := make([]reflect.Value, len(s.Fields))
+	for i, f := range s.Fields {
+		values[i] = v.FieldByIndex(f.Index)
+	}
+	return values
+}
@@ -0,0 +1,127 @@
+package crawler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type book struct {
+	Title string  `crawler:"title,css=h1"`
+	Link  string  `crawler:"link,css=a,attr=href"`
+	Price float64 `crawler:",css=.price,required" json:"price"`
+	Notes string  `crawler:"-"`
+	Stock int
+}
+
+type author struct {
+	Name string
+}
+
+func TestHandleItem(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	var mutex sync.Mutex
+	var results []string
+	c := NewCrawler(&Settings{}).ClearPipelines()
+	HandleItem(c, func(b book, ctx *Context) (book, error) {
+		if b.Price == 0 {
+			return b, DropItem("no price")
+		}
+		b.Title = strings.ToUpper(b.Title)
+		return b, nil
+	})
+	HandleItem(c, func(a *author, ctx *Context) (*author, error) {
+		return &author{Name: a.Name + "!"}, nil
+	})
+	c.AddItemPipelineFunc(func(item interface{}, ctx *Context) interface{} {
+		mutex.Lock()
+		results = append(results, fmt.Sprintf("%T %v", item, item))
+		mutex.Unlock()
+		return nil
+	}).
+		OnResponse(func(res *Response, ctx *Context) {
+			ctx.Emit(book{Title: "a", Price: 1})
+			ctx.Emit(&book{Title: "b", Price: 2})
+			ctx.Emit(book{Title: "c"})
+			ctx.Emit(author{Name: "d"})
+		}).
+		WithStartRequests(func(ctx *Context) []*Request {
+			return []*Request{GetURL(server.URL)}
+		}).
+		Start(true)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	got := strings.Join(results, "|")
+	for _, want := range []string{"crawler.book {A  1  0}", "*crawler.book &{B  2  0}", "crawler.author {d!}"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("results = %s, want %s", got, want)
+		}
+	}
+	if len(results) != 3 || c.Stats().Get("item/dropped_reasons_count/no price") != 1 {
+		t.Errorf("results = %s, stats = %v", got, c.Stats().Values())
+	}
+}
+
+func TestItemSchema(t *testing.T) {
+	schema, err := SchemaOf(&book{})
+	if again, _ := SchemaOf(book{}); err != nil || schema == nil || !schema.Tagged || again != schema {
+		t.Fatalf("schema = %+v, %v", schema, err)
+	}
+	if other, err := SchemaOf(map[string]string{}); other != nil || err != nil {
+		t.Errorf("map schema = %+v, %v", other, err)
+	}
+	if names := strings.Join(schema.Names(), ","); names != "title,link,price,Stock" {
+		t.Errorf("names = %s", names)
+	}
+	link, _ := schema.Field("link")
+	price, _ := schema.Field("price")
+	if link.CSS != "a" || link.Attr != "href" || price.CSS != ".price" || price.Options["required"] != "" {
+		t.Errorf("fields = %+v, %+v", link, price)
+	}
+	if _, ok := price.Options["required"]; !ok {
+		t.Error("option without value should be kept")
+	}
+
+	cases := map[string][][2]string{
+		"heading,css='h1, h2',required":            {{"css", "h1, h2"}, {"required", ""}},
+		"a,css=:is(h1,h2),attr=title":              {{"css", ":is(h1,h2)"}, {"attr", "title"}},
+		"b,xpath=//a[contains(@class, 'x')] | //b": {{"xpath", "//a[contains(@class, 'x')] | //b"}},
+		"c,css='a[href=x], b'":                     {{"css", "a[href=x], b"}},
+	}
+	for tag, want := range cases {
+		if _, options, err := parseCrawlerTag(tag); err != nil || fmt.Sprint(options) != fmt.Sprint(want) {
+			t.Errorf("parseCrawlerTag(%q) = %q, %v, want %q", tag, options, err, want)
+		}
+	}
+	for _, tag := range []string{"title,css=h1,h2", "title,css=h1, h2", "title,x"} {
+		if _, options, err := parseCrawlerTag(tag); err == nil {
+			t.Errorf("parseCrawlerTag(%q) = %q, want error", tag, options)
+		}
+	}
+	type badItem struct {
+		Title string `crawler:"title,css=h1,h2"`
+	}
+	if schema, err := SchemaOf(&badItem{}); schema != nil || err == nil || !strings.Contains(err.Error(), "badItem.Title") {
+		t.Errorf("SchemaOf(badItem) = %+v, %v", schema, err)
+	}
+	if _, err := marshalItem(badItem{}); err == nil {
+		t.Error("marshalItem should fail for an invalid crawler tag")
+	}
+
+	data, err := marshalItem(&book{Title: "<Go>", Link: "/go", Price: 9.5, Notes: "x"})
+	if err != nil || string(data) != `{"title":"<Go>","link":"/go","price":9.5,"Stock":0}` {
+		t.Errorf("marshalItem = %s, %v", data, err)
+	}
+	row, err := (&SQLPipeline{}).toRow(book{Title: "Go"})
+	if err != nil || strings.Join(row.columns, ",") != "title,link,price,stock" {
+		t.Errorf("columns = %v, %v", row, err)
+	}
+}
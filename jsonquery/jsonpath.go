@@ -0,0 +1,622 @@
+package jsonquery
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/groupcache/lru"
+)
+
+// Path 编译后的JSONPath表达式
+type Path struct {
+	expr  string
+	steps []*step
+}
+
+type step struct {
+	recursive bool
+	wildcard  bool
+	names     []string
+	indices   []int
+	slice     *slice
+	filter    *filter
+}
+
+type slice struct {
+	start, end, step int
+	hasStart, hasEnd bool
+}
+
+type filter struct {
+	path  *Path
+	op    string
+	value interface{}
+}
+
+// SyntaxError JSONPath语法错误
+type SyntaxError struct {
+	Expr   string
+	Offset int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("jsonpath %q: %s at offset %d", e.Expr, e.Msg, e.Offset)
+}
+
+// DisablePathCache will disable caching for compiled JSONPath if value is true.
+var DisablePathCache = false
+
+// PathCacheMaxEntries allows how many compiled JSONPath can be caching.
+// Will disable caching if PathCacheMaxEntries <= 0.
+var PathCacheMaxEntries = 100
+
+var (
+	cacheOnce  sync.Once
+	cache      *lru.Cache
+	cacheMutex sync.Mutex
+)
+
+func getPath(expr string) (*Path, error) {
+	if DisablePathCache || PathCacheMaxEntries <= 0 {
+		return Compile(expr)
+	}
+	cacheOnce.Do(func() {
+		cache = lru.New(PathCacheMaxEntries)
+	})
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	if v, ok := cache.Get(expr); ok {
+		return v.(*Path), nil
+	}
+	v, err := Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	cache.Add(expr, v)
+	return v, nil
+}
+
+// Compile 编译JSONPath表达式, 支持$、@、.name、['name']、[n]、[*]、..、[start:end:step]、[a,b]和[?(@.x op v)],
+// 不以$或@开头时按GJSON风格的点路径处理, 如data.items.#.id
+func Compile(expr string) (*Path, error) {
+	p := &pathParser{expr: expr}
+	steps, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return &Path{expr: expr, steps: steps}, nil
+}
+
+// MustCompile is like Compile but panics if the expression cannot be parsed.
+func MustCompile(expr string) *Path {
+	path, err := Compile(expr)
+	if err != nil {
+		panic(err)
+	}
+	return path
+}
+
+func (p *Path) String() string {
+	return p.expr
+}
+
+// Select 返回value中所有匹配的值
+func (p *Path) Select(value interface{}) []interface{} {
+	current := []interface{}{value}
+	for _, s := range p.steps {
+		var next []interface{}
+		for _, v := range current {
+			if s.recursive {
+				for _, d := range descendants(v, nil) {
+					next = s.apply(d, next)
+				}
+			} else {
+				next = s.apply(v, next)
+			}
+		}
+		current = next
+	}
+	return current
+}
+
+func descendants(value interface{}, result []interface{}) []interface{} {
+	result = append(result, value)
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			result = descendants(v[key], result)
+		}
+	case []interface{}:
+		for _, item := range v {
+			result = descendants(item, result)
+		}
+	}
+	return result
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *step) apply(value interface{}, result []interface{}) []interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		switch {
+		case s.wildcard:
+			for _, key := range sortedKeys(v) {
+				result = append(result, v[key])
+			}
+		case s.filter != nil:
+			for _, key := range sortedKeys(v) {
+				if s.filter.match(v[key]) {
+					result = append(result, v[key])
+				}
+			}
+		default:
+			for _, name := range s.names {
+				if item, ok := v[name]; ok {
+					result = append(result, item)
+				}
+			}
+		}
+	case []interface{}:
+		switch {
+		case s.wildcard:
+			result = append(result, v...)
+		case s.filter != nil:
+			for _, item := range v {
+				if s.filter.match(item) {
+					result = append(result, item)
+				}
+			}
+		case s.slice != nil:
+			result = s.slice.apply(v, result)
+		default:
+			// 编译后的step在并发查询间共享, 不能修改s.indices
+			index := func(i int) {
+				if i < 0 {
+					i += len(v)
+				}
+				if i >= 0 && i < len(v) {
+					result = append(result, v[i])
+				}
+			}
+			for _, i := range s.indices {
+				index(i)
+			}
+			// 点路径中的数字作为数组下标
+			for _, name := range s.names {
+				if i, err := strconv.Atoi(name); err == nil {
+					index(i)
+				}
+			}
+		}
+	}
+	return result
+}
+
+func (s *slice) apply(items []interface{}, result []interface{}) []interface{} {
+	length := len(items)
+	// normalize 负数下标从末尾计算, 再限制在[lower, upper]之间
+	normalize := func(i, lower, upper int) int {
+		if i < 0 {
+			i += length
+		}
+		if i < lower {
+			return lower
+		}
+		if i > upper {
+			return upper
+		}
+		return i
+	}
+	// 步长为0时不选择任何元素
+	step := s.step
+	if step == 0 {
+		return result
+	}
+	if step > 0 {
+		start, end := 0, length
+		if s.hasStart {
+			start = normalize(s.start, 0, length)
+		}
+		if s.hasEnd {
+			end = normalize(s.end, 0, length)
+		}
+		for i := start; i < end; i += step {
+			result = append(result, items[i])
+		}
+	} else {
+		// 步长为负时下标可以为-1, 表示一直取到第一个元素
+		start, end := length-1, -1
+		if s.hasStart {
+			start = normalize(s.start, -1, length-1)
+		}
+		if s.hasEnd {
+			end = normalize(s.end, -1, length-1)
+		}
+		for i := start; i > end; i += step {
+			result = append(result, items[i])
+		}
+	}
+	return result
+}
+
+func (f *filter) match(value interface{}) bool {
+	matched := f.path.Select(value)
+	if f.op == "" {
+		return len(matched) > 0
+	}
+	for _, m := range matched {
+		if compare(m, f.op, f.value) {
+			return true
+		}
+	}
+	return false
+}
+
+func compare(left interface{}, op string, right interface{}) bool {
+	if l, ok := toFloat(left); ok {
+		if r, ok := toFloat(right); ok {
+			switch op {
+			case "==":
+				return l == r
+			case "!=":
+				return l != r
+			case "<":
+				return l < r
+			case "<=":
+				return l <= r
+			case ">":
+				return l > r
+			case ">=":
+				return l >= r
+			}
+			return false
+		}
+	}
+	if l, ok := left.(string); ok {
+		if r, ok := right.(string); ok {
+			switch op {
+			case "==":
+				return l == r
+			case "!=":
+				return l != r
+			case "<":
+				return l < r
+			case "<=":
+				return l <= r
+			case ">":
+				return l > r
+			case ">=":
+				return l >= r
+			}
+			return false
+		}
+	}
+	switch op {
+	case "==":
+		return left == right
+	case "!=":
+		return left != right
+	}
+	return false
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+type pathParser struct {
+	expr string
+	pos  int
+}
+
+func (p *pathParser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Expr: p.expr, Offset: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *pathParser) peek() byte {
+	if p.pos < len(p.expr) {
+		return p.expr[p.pos]
+	}
+	return 0
+}
+
+func (p *pathParser) skipSpaces() {
+	for p.pos < len(p.expr) && p.expr[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *pathParser) parse() ([]*step, error) {
+	p.skipSpaces()
+	switch p.peek() {
+	case '$', '@':
+		p.pos++
+	default:
+		return p.parseDotPath()
+	}
+	return p.parseSteps(false)
+}
+
+// parseDotPath GJSON风格的点路径, #表示数组所有元素
+func (p *pathParser) parseDotPath() ([]*step, error) {
+	var steps []*step
+	if strings.TrimSpace(p.expr) == "" {
+		return steps, nil
+	}
+	for _, part := range strings.Split(p.expr, ".") {
+		switch part {
+		case "":
+			return nil, p.errorf("empty path segment")
+		case "*", "#":
+			steps = append(steps, &step{wildcard: true})
+		default:
+			steps = append(steps, &step{names: []string{part}})
+		}
+		p.pos += len(part) + 1
+	}
+	return steps, nil
+}
+
+// parseSteps 解析$或@之后的各级路径, inFilter为true时遇到比较运算符或)即结束
+func (p *pathParser) parseSteps(inFilter bool) ([]*step, error) {
+	var steps []*step
+	for p.pos < len(p.expr) {
+		c := p.peek()
+		if inFilter && (c == ' ' || c == ')' || c == '=' || c == '!' || c == '<' || c == '>') {
+			break
+		}
+		switch c {
+		case '.':
+			p.pos++
+			recursive := false
+			if p.peek() == '.' {
+				recursive = true
+				p.pos++
+			}
+			if p.peek() == '[' {
+				s, err := p.parseBracket()
+				if err != nil {
+					return nil, err
+				}
+				s.recursive = recursive
+				steps = append(steps, s)
+				continue
+			}
+			if p.peek() == '*' {
+				p.pos++
+				steps = append(steps, &step{wildcard: true, recursive: recursive})
+				continue
+			}
+			name := p.parseName()
+			if name == "" {
+				return nil, p.errorf("expected member name")
+			}
+			steps = append(steps, &step{names: []string{name}, recursive: recursive})
+		case '[':
+			s, err := p.parseBracket()
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, s)
+		default:
+			return nil, p.errorf("unexpected character %q", c)
+		}
+	}
+	return steps, nil
+}
+
+func (p *pathParser) parseName() string {
+	start := p.pos
+	for p.pos < len(p.expr) {
+		c := p.expr[p.pos]
+		if c == '.' || c == '[' || c == ' ' || c == ')' || c == '=' || c == '!' || c == '<' || c == '>' {
+			break
+		}
+		p.pos++
+	}
+	return p.expr[start:p.pos]
+}
+
+func (p *pathParser) parseBracket() (*step, error) {
+	p.pos++
+	p.skipSpaces()
+	s := &step{}
+	switch c := p.peek(); {
+	case c == '*':
+		p.pos++
+		s.wildcard = true
+	case c == '?':
+		f, err := p.parseFilter()
+		if err != nil {
+			return nil, err
+		}
+		s.filter = f
+	case c == '\'' || c == '"':
+		for {
+			name, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+			s.names = append(s.names, name)
+			p.skipSpaces()
+			if p.peek() != ',' {
+				break
+			}
+			p.pos++
+			p.skipSpaces()
+		}
+	default:
+		if err := p.parseIndices(s); err != nil {
+			return nil, err
+		}
+	}
+	p.skipSpaces()
+	if p.peek() != ']' {
+		return nil, p.errorf("expected ]")
+	}
+	p.pos++
+	return s, nil
+}
+
+func (p *pathParser) parseString() (string, error) {
+	quote := p.peek()
+	start := p.pos
+	p.pos++
+	var buf strings.Builder
+	for p.pos < len(p.expr) {
+		c := p.expr[p.pos]
+		switch {
+		case c == '\\' && p.pos+1 < len(p.expr):
+			buf.WriteByte(p.expr[p.pos+1])
+			p.pos += 2
+		case c == quote:
+			p.pos++
+			return buf.String(), nil
+		default:
+			buf.WriteByte(c)
+			p.pos++
+		}
+	}
+	p.pos = start
+	return "", p.errorf("unterminated string")
+}
+
+func (p *pathParser) parseInt() (int, bool) {
+	start := p.pos
+	if c := p.peek(); c == '-' || c == '+' {
+		p.pos++
+	}
+	for p.pos < len(p.expr) && p.expr[p.pos] >= '0' && p.expr[p.pos] <= '9' {
+		p.pos++
+	}
+	i, err := strconv.Atoi(p.expr[start:p.pos])
+	if err != nil {
+		p.pos = start
+		return 0, false
+	}
+	return i, true
+}
+
+func (p *pathParser) parseIndices(s *step) error {
+	first, hasFirst := p.parseInt()
+	p.skipSpaces()
+	if p.peek() == ':' {
+		sl := &slice{start: first, hasStart: hasFirst, step: 1}
+		p.pos++
+		p.skipSpaces()
+		sl.end, sl.hasEnd = p.parseInt()
+		p.skipSpaces()
+		if p.peek() == ':' {
+			p.pos++
+			p.skipSpaces()
+			if step, ok := p.parseInt(); ok {
+				sl.step = step
+			}
+		}
+		s.slice = sl
+		return nil
+	}
+	if !hasFirst {
+		return p.errorf("expected index, name, * or filter")
+	}
+	s.indices = append(s.indices, first)
+	for p.peek() == ',' {
+		p.pos++
+		p.skipSpaces()
+		i, ok := p.parseInt()
+		if !ok {
+			return p.errorf("expected index")
+		}
+		s.indices = append(s.indices, i)
+		p.skipSpaces()
+	}
+	return nil
+}
+
+func (p *pathParser) parseFilter() (*filter, error) {
+	p.pos++
+	if p.peek() != '(' {
+		return nil, p.errorf("expected ( after ?")
+	}
+	p.pos++
+	p.skipSpaces()
+	if p.peek() != '@' {
+		return nil, p.errorf("filter must start with @")
+	}
+	p.pos++
+	steps, err := p.parseSteps(true)
+	if err != nil {
+		return nil, err
+	}
+	f := &filter{path: &Path{expr: p.expr, steps: steps}}
+	p.skipSpaces()
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if strings.HasPrefix(p.expr[p.pos:], op) {
+			f.op = op
+			p.pos += len(op)
+			break
+		}
+	}
+	if f.op != "" {
+		p.skipSpaces()
+		if f.value, err = p.parseLiteral(); err != nil {
+			return nil, err
+		}
+		p.skipSpaces()
+	}
+	if p.peek() != ')' {
+		return nil, p.errorf("expected )")
+	}
+	p.pos++
+	return f, nil
+}
+
+func (p *pathParser) parseLiteral() (interface{}, error) {
+	switch c := p.peek(); {
+	case c == '\'' || c == '"':
+		return p.parseString()
+	case strings.HasPrefix(p.expr[p.pos:], "true"):
+		p.pos += 4
+		return true, nil
+	case strings.HasPrefix(p.expr[p.pos:], "false"):
+		p.pos += 5
+		return false, nil
+	case strings.HasPrefix(p.expr[p.pos:], "null"):
+		p.pos += 4
+		return nil, nil
+	}
+	start := p.pos
+	for p.pos < len(p.expr) && strings.IndexByte("+-.eE0123456789", p.expr[p.pos]) >= 0 {
+		p.pos++
+	}
+	f, err := strconv.ParseFloat(p.expr[start:p.pos], 64)
+	if err != nil {
+		p.pos = start
+		return nil, p.errorf("invalid literal")
+	}
+	return f, nil
+}
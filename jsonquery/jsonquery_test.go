@@ -0,0 +1,74 @@
+package jsonquery
+
+import (
+	"reflect"
+	"testing"
+)
+
+const doc = `{
+	"data": {
+		"total": 3,
+		"items": [
+			{"id": 1, "name": "apple", "price": 5.5, "tags": ["fruit"]},
+			{"id": 2, "name": "pear", "price": 12, "tags": []},
+			{"id": 9007199254740993, "name": "plum", "price": 8}
+		]
+	},
+	"ok": true
+}`
+
+func TestJSONPath(t *testing.T) {
+	root, err := NewSelector([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		path string
+		want []string
+	}{
+		{"$.data.total", []string{"3"}},
+		{"$.data.items[*].name", []string{"apple", "pear", "plum"}},
+		{"$['data']['items'][0].name", []string{"apple"}},
+		{"$.data.items[-1].name", []string{"plum"}},
+		{"$.data.items[0,2].id", []string{"1", "9007199254740993"}},
+		{"$.data.items[1:].name", []string{"pear", "plum"}},
+		{"$.data.items[::-1].name", []string{"plum", "pear", "apple"}},
+		{"$.data.items[:-4:-1].name", []string{"plum", "pear", "apple"}},
+		{"$.data.items[-8::-1].name", []string{}},
+		{"$.data.items[5:0:-1].name", []string{"plum", "pear"}},
+		{"$.data.items[::0].name", []string{}},
+		{"$.data.items[1::].name", []string{"pear", "plum"}},
+		{"$..name", []string{"apple", "pear", "plum"}},
+		{"$.data.items[?(@.price > 6)].name", []string{"pear", "plum"}},
+		{"$.data.items[?(@.name == 'pear')].id", []string{"2"}},
+		{"$.data.items[?(@.tags)].name", []string{"apple", "pear"}},
+		{"$.ok", []string{"true"}},
+		{"data.items.#.name", []string{"apple", "pear", "plum"}},
+		{"data.items.1.name", []string{"pear"}},
+		{"$.missing", []string{}},
+	}
+	for _, c := range cases {
+		got := root.JSONPath(c.path).Strings()
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("JSONPath(%q) = %v, want %v", c.path, got, c.want)
+		}
+	}
+
+	if ids := root.JSONPath("$.data.items[*].id").Ints(); ids[2] != 9007199254740993 {
+		t.Errorf("lost integer precision: %v", ids)
+	}
+	items := root.JSONPath("$.data.items[*]")
+	if names := items.JSONPath("$.name").Strings(); len(names) != 3 {
+		t.Errorf("relative JSONPath on Selectors = %v", names)
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	for _, expr := range []string{"$.data[", "$.data[?(@.a ==)]", "$['a", "$..", "a..b"} {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("Compile(%q) should fail", expr)
+		} else if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("Compile(%q) returned %T, want *SyntaxError", expr, err)
+		}
+	}
+}
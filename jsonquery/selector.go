@@ -0,0 +1,239 @@
+/*
+Package jsonquery provides extract data from JSON documents using JSONPath expression.
+*/
+package jsonquery
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Selector JSON文档中的一个值
+type Selector struct {
+	Value interface{}
+}
+
+// Selectors Selector数组
+type Selectors []*Selector
+
+// NewSelector 解析JSON生成selector, 数字保存为json.Number以免丢失精度
+func NewSelector(content []byte) (*Selector, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return &Selector{Value: value}, nil
+}
+
+// JSONPath 通过JSONPath选择值, 表达式错误时返回空结果
+func (s *Selector) JSONPath(path string) Selectors {
+	ss, _ := s.TryJSONPath(path)
+	return ss
+}
+
+// TryJSONPath 通过JSONPath选择值, 返回表达式的语法错误
+func (s *Selector) TryJSONPath(path string) (Selectors, error) {
+	p, err := getPath(path)
+	if err != nil {
+		return Selectors{}, err
+	}
+	values := p.Select(s.Value)
+	selectors := make(Selectors, len(values))
+	for i, value := range values {
+		selectors[i] = &Selector{Value: value}
+	}
+	return selectors, nil
+}
+
+// Exists 值是否存在
+func (s *Selector) Exists() bool {
+	return s != nil && s.Value != nil
+}
+
+// String 字符串形式, 字符串原样返回, 其他类型返回JSON编码
+func (s *Selector) String() string {
+	if s == nil {
+		return ""
+	}
+	switch v := s.Value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return s.Raw()
+}
+
+// Text 同String, 与htmlquery.Selector保持一致
+func (s *Selector) Text() string {
+	return s.String()
+}
+
+// Raw 值的JSON编码
+func (s *Selector) Raw() string {
+	if s == nil {
+		return ""
+	}
+	data, _ := json.Marshal(s.Value)
+	return string(data)
+}
+
+// Int 转换为整数, 无法转换时返回0
+func (s *Selector) Int() int64 {
+	if s == nil {
+		return 0
+	}
+	switch v := s.Value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return int64(f)
+	case string:
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return i
+		}
+		f, _ := strconv.ParseFloat(v, 64)
+		return int64(f)
+	case bool:
+		if v {
+			return 1
+		}
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
+
+// Float 转换为浮点数, 无法转换时返回0
+func (s *Selector) Float() float64 {
+	if s == nil {
+		return 0
+	}
+	switch v := s.Value.(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	case bool:
+		if v {
+			return 1
+		}
+	case float64:
+		return v
+	}
+	return 0
+}
+
+// Bool 转换为布尔值
+func (s *Selector) Bool() bool {
+	if s == nil {
+		return false
+	}
+	switch v := s.Value.(type) {
+	case bool:
+		return v
+	case string:
+		b, _ := strconv.ParseBool(v)
+		return b
+	case json.Number:
+		f, _ := v.Float64()
+		return f != 0
+	}
+	return false
+}
+
+// Array 数组的所有元素, 非数组返回空
+func (s *Selector) Array() Selectors {
+	if s == nil {
+		return Selectors{}
+	}
+	items, _ := s.Value.([]interface{})
+	selectors := make(Selectors, len(items))
+	for i, item := range items {
+		selectors[i] = &Selector{Value: item}
+	}
+	return selectors
+}
+
+// Map 对象的所有字段, 非对象返回nil
+func (s *Selector) Map() map[string]interface{} {
+	if s == nil {
+		return nil
+	}
+	m, _ := s.Value.(map[string]interface{})
+	return m
+}
+
+// Decode 将值解码到v中
+func (s *Selector) Decode(v interface{}) error {
+	if s == nil {
+		return fmt.Errorf("decode empty selector")
+	}
+	data, err := json.Marshal(s.Value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// JSONPath 对每个值执行JSONPath, 合并结果
+func (ss Selectors) JSONPath(path string) Selectors {
+	result := Selectors{}
+	for _, s := range ss {
+		result = append(result, s.JSONPath(path)...)
+	}
+	return result
+}
+
+// Values 所有原始值
+func (ss Selectors) Values() []interface{} {
+	values := make([]interface{}, len(ss))
+	for i, s := range ss {
+		values[i] = s.Value
+	}
+	return values
+}
+
+// Strings 所有值的字符串形式
+func (ss Selectors) Strings() []string {
+	result := make([]string, len(ss))
+	for i, s := range ss {
+		result[i] = s.String()
+	}
+	return result
+}
+
+// Texts 同Strings, 与htmlquery.Selectors保持一致
+func (ss Selectors) Texts() []string {
+	return ss.Strings()
+}
+
+// Ints 所有值转换为整数
+func (ss Selectors) Ints() []int64 {
+	result := make([]int64, len(ss))
+	for i, s := range ss {
+		result[i] = s.Int()
+	}
+	return result
+}
+
+// Floats 所有值转换为浮点数
+func (ss Selectors) Floats() []float64 {
+	result := make([]float64, len(ss))
+	for i, s := range ss {
+		result[i] = s.Float()
+	}
+	return result
+}
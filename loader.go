@@ -0,0 +1,373 @@
+package crawler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qhzhyt/go-crawler/htmlquery"
+	"github.com/qhzhyt/go-crawler/jsonquery"
+)
+
+// FieldErrors ItemLoader各字段的错误
+type FieldErrors map[string]error
+
+func (e FieldErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	messages := make([]string, len(fields))
+	for i, field := range fields {
+		messages[i] = field + ": " + e[field].Error()
+	}
+	return "load item failed: " + strings.Join(messages, "; ")
+}
+
+// ItemLoader 从页面中提取字段填充item
+//
+// 每次Add的值先经过该次传入的处理器, 再追加到字段的值列表; Load时字段的值列表经过Output设置的处理器后写入item.
+// item为结构体指针时按ItemSchema的字段名匹配, 非切片字段取第一个值; item为map时单个值直接写入, 多个值写入切片
+type ItemLoader struct {
+	// Selector 执行AddCSS和AddXpath的节点
+	Selector *htmlquery.Selector
+	// JSON 执行AddJSONPath的JSON文档
+	JSON *jsonquery.Selector
+	// BaseURL AbsoluteURL处理器使用的基准地址
+	BaseURL string
+
+	item     interface{}
+	response *Response
+	fields   []string
+	values   map[string][]interface{}
+	outputs  map[string][]Processor
+	errors   FieldErrors
+}
+
+// NewItemLoader 创建ItemLoader, item为结构体指针或map[string]...
+func NewItemLoader(item interface{}, selector *htmlquery.Selector) *ItemLoader {
+	return &ItemLoader{
+		Selector: selector,
+		item:     item,
+		values:   map[string][]interface{}{},
+		outputs:  map[string][]Processor{},
+		errors:   FieldErrors{},
+	}
+}
+
+// Loader 创建从响应中提取item的ItemLoader, BaseURL为响应的地址
+func (res *Response) Loader(item interface{}) *ItemLoader {
+	loader := NewItemLoader(item, nil)
+	loader.response = res
+	loader.BaseURL = res.URL
+	return loader
+}
+
+func (l *ItemLoader) document() (*htmlquery.Selector, error) {
+	if l.Selector == nil && l.response != nil {
+		doc, err := l.response.Document()
+		if err != nil {
+			return nil, err
+		}
+		l.Selector = doc
+	}
+	if l.Selector == nil {
+		return nil, errors.New("no html document to select from")
+	}
+	return l.Selector, nil
+}
+
+// AddCSS 添加CSS选中节点的文本, 可使用::attr(name)选择属性
+func (l *ItemLoader) AddCSS(field, css string, processors ...Processor) *ItemLoader {
+	doc, err := l.document()
+	if err != nil {
+		return l.fail(field, err)
+	}
+	ss, err := doc.TryCSS(css)
+	if err != nil {
+		return l.fail(field, err)
+	}
+	return l.AddValue(field, ss.Texts(), processors...)
+}
+
+// AddXpath 添加Xpath选中节点的文本
+func (l *ItemLoader) AddXpath(field, path string, processors ...Processor) *ItemLoader {
+	doc, err := l.document()
+	if err != nil {
+		return l.fail(field, err)
+	}
+	ss, err := doc.TryXpath(path)
+	if err != nil {
+		return l.fail(field, err)
+	}
+	return l.AddValue(field, ss.Texts(), processors...)
+}
+
+// AddJSONPath 添加JSONPath选中的值
+func (l *ItemLoader) AddJSONPath(field, path string, processors ...Processor) *ItemLoader {
+	if l.JSON == nil && l.response != nil {
+		doc, err := l.response.JSONDocument()
+		if err != nil {
+			return l.fail(field, err)
+		}
+		l.JSON = doc
+	}
+	if l.JSON == nil {
+		return l.fail(field, errors.New("no json document to select from"))
+	}
+	ss, err := l.JSON.TryJSONPath(path)
+	if err != nil {
+		return l.fail(field, err)
+	}
+	values := make([]interface{}, len(ss))
+	for i, s := range ss {
+		values[i] = s.Value
+	}
+	return l.AddValue(field, values, processors...)
+}
+
+// AddValue 添加值, value为切片时添加其中的每个元素
+func (l *ItemLoader) AddValue(field string, value interface{}, processors ...Processor) *ItemLoader {
+	var values []interface{}
+	switch v := reflect.ValueOf(value); {
+	case !v.IsValid():
+		return l
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8:
+		for i := 0; i < v.Len(); i++ {
+			values = append(values, v.Index(i).Interface())
+		}
+	default:
+		values = []interface{}{value}
+	}
+	values, err := Compose(processors...)(values, l)
+	if err != nil {
+		return l.fail(field, err)
+	}
+	l.addField(field)
+	l.values[field] = append(l.values[field], values...)
+	return l
+}
+
+// AddFromTags 按item结构体crawler标签中的css、xpath和attr添加字段
+func (l *ItemLoader) AddFromTags(processors ...Processor) *ItemLoader {
+	schema, err := SchemaOf(l.item)
+	if err != nil {
+		return l.fail("", err)
+	}
+	if schema == nil {
+		return l
+	}
+	for _, f := range schema.Fields {
+		switch {
+		case f.CSS != "" && f.Attr != "":
+			l.AddCSS(f.Name, f.CSS+"::attr("+f.Attr+")", processors...)
+		case f.CSS != "":
+			l.AddCSS(f.Name, f.CSS, processors...)
+		case f.XPath != "" && f.Attr != "":
+			l.AddXpath(f.Name, f.XPath+"/@"+f.Attr, processors...)
+		case f.XPath != "":
+			l.AddXpath(f.Name, f.XPath, processors...)
+		}
+	}
+	return l
+}
+
+// Output 设置字段写入item前执行的处理器
+func (l *ItemLoader) Output(field string, processors ...Processor) *ItemLoader {
+	l.addField(field)
+	l.outputs[field] = processors
+	return l
+}
+
+// Values 字段当前的值列表
+func (l *ItemLoader) Values(field string) []interface{} {
+	return l.values[field]
+}
+
+func (l *ItemLoader) addField(field string) {
+	if _, ok := l.values[field]; !ok {
+		l.fields = append(l.fields, field)
+		l.values[field] = nil
+	}
+}
+
+func (l *ItemLoader) fail(field string, err error) *ItemLoader {
+	l.addField(field)
+	if l.errors[field] == nil {
+		l.errors[field] = err
+	}
+	return l
+}
+
+// Load 执行输出处理器并写入item, 返回item和FieldErrors; 出错的字段不写入, 其他字段照常写入
+func (l *ItemLoader) Load() (interface{}, error) {
+	target := reflect.ValueOf(l.item)
+	for target.Kind() == reflect.Ptr && !target.IsNil() && target.Elem().Kind() == reflect.Ptr {
+		target = target.Elem()
+	}
+	switch {
+	case target.Kind() == reflect.Map && target.Type().Key().Kind() == reflect.String:
+		if target.IsNil() {
+			return l.item, errors.New("load item failed: nil map")
+		}
+	case target.Kind() == reflect.Ptr && !target.IsNil() && target.Elem().Kind() == reflect.Struct:
+		target = target.Elem()
+	default:
+		return l.item, fmt.Errorf("load item failed: unsupported item type %T", l.item)
+	}
+	for _, field := range l.fields {
+		if l.errors[field] != nil {
+			continue
+		}
+		values, err := Compose(l.outputs[field]...)(l.values[field], l)
+		if err == nil {
+			err = setItemField(target, field, values)
+		}
+		if err != nil {
+			l.errors[field] = err
+		}
+	}
+	if len(l.errors) > 0 {
+		return l.item, l.errors
+	}
+	return l.item, nil
+}
+
+// setItemField 将值写入结构体字段或map
+func setItemField(target reflect.Value, field string, values []interface{}) error {
+	if target.Kind() == reflect.Map {
+		t := target.Type().Elem()
+		if len(values) == 0 {
+			return nil
+		}
+		var v reflect.Value
+		var err error
+		if len(values) > 1 && t.Kind() == reflect.Interface {
+			v = reflect.ValueOf(values)
+		} else {
+			v, err = convertValues(values, t)
+		}
+		if err != nil {
+			return err
+		}
+		target.SetMapIndex(reflect.ValueOf(field).Convert(target.Type().Key()), v)
+		return nil
+	}
+	schema, err := SchemaOf(target.Interface())
+	if err != nil {
+		return err
+	}
+	f, ok := schema.Field(field)
+	if !ok {
+		sf, found := schema.Type.FieldByName(field)
+		if !found || sf.PkgPath != "" {
+			return fmt.Errorf("no field %s in %s", field, schema.Type)
+		}
+		f = &ItemField{Name: field, Index: sf.Index, Type: sf.Type}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	v, err := convertValues(values, f.Type)
+	if err != nil {
+		return err
+	}
+	target.FieldByIndex(f.Index).Set(v)
+	return nil
+}
+
+// convertValues 将值列表转换为t类型, 非切片类型取第一个值
+func convertValues(values []interface{}, t reflect.Type) (reflect.Value, error) {
+	if t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8 {
+		slice := reflect.MakeSlice(t, len(values), len(values))
+		for i, value := range values {
+			v, err := convertValue(value, t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			slice.Index(i).Set(v)
+		}
+		return slice, nil
+	}
+	return convertValue(values[0], t)
+}
+
+// convertValue 将单个值转换为t类型, 字符串按t解析
+func convertValue(value interface{}, t reflect.Type) (reflect.Value, error) {
+	if value == nil {
+		return reflect.Zero(t), nil
+	}
+	v := reflect.ValueOf(value)
+	if v.Type().AssignableTo(t) {
+		return v, nil
+	}
+	if t.Kind() == reflect.Ptr {
+		elem, err := convertValue(value, t.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		p := reflect.New(t.Elem())
+		p.Elem().Set(elem)
+		return p, nil
+	}
+	if n, ok := value.(json.Number); ok {
+		value, v = n.String(), reflect.ValueOf(n.String())
+	}
+	if s, ok := value.(string); ok && v.Kind() == reflect.String {
+		s = strings.TrimSpace(s)
+		var parsed interface{}
+		var err error
+		switch t.Kind() {
+		case reflect.String:
+			return reflect.ValueOf(value).Convert(t), nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			parsed, err = strconv.ParseInt(s, 10, t.Bits())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			parsed, err = strconv.ParseUint(s, 10, t.Bits())
+		case reflect.Float32, reflect.Float64:
+			parsed, err = strconv.ParseFloat(s, t.Bits())
+		case reflect.Bool:
+			parsed, err = strconv.ParseBool(s)
+		case reflect.Struct:
+			if t != timeType {
+				return reflect.Value{}, fmt.Errorf("cannot convert %q to %s", s, t)
+			}
+			parsed, err = time.Parse(time.RFC3339, s)
+		default:
+			return reflect.Value{}, fmt.Errorf("cannot convert %q to %s", s, t)
+		}
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(parsed).Convert(t), nil
+	}
+	if t.Kind() == reflect.String {
+		return reflect.ValueOf(valueString(value)).Convert(t), nil
+	}
+	if isNumberKind(v.Kind()) && isNumberKind(t.Kind()) {
+		// 浮点数转换为整数时须为整数值且不超出范围, 不截断
+		if (v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64) && t.Kind() <= reflect.Uint64 {
+			f := v.Float()
+			min, max := -math.Ldexp(1, t.Bits()-1), math.Ldexp(1, t.Bits()-1)
+			if t.Kind() >= reflect.Uint {
+				min, max = 0, math.Ldexp(1, t.Bits())
+			}
+			if f != math.Trunc(f) || f < min || f >= max {
+				return reflect.Value{}, fmt.Errorf("cannot convert %v to %s", f, t)
+			}
+		}
+		return v.Convert(t), nil
+	}
+	return reflect.Value{}, fmt.Errorf("cannot convert %T to %s", value, t)
+}
+
+func isNumberKind(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Float64
+}
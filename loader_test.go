@@ -0,0 +1,97 @@
+package crawler
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type loadedProduct struct {
+	Title     string    `crawler:"title,css=h1"`
+	Link      string    `crawler:"link,css=a.more,attr=href"`
+	Price     float64   `json:"price"`
+	Tags      []string  `json:"tags"`
+	Published time.Time `json:"published"`
+	Stock     *int
+	Summary   string
+}
+
+func TestItemLoader(t *testing.T) {
+	ctx := &Context{Settings: DefaultSettings()}
+	res := &Response{URL: "http://example.com/books/1", Type: HTMLContent, context: ctx, Body: []byte(`
+		<h1>  Go  in Action </h1>
+		<a class="more" href="../all">more</a>
+		<span class="price">$1,234.50</span>
+		<ul><li> go </li><li></li><li>books</li></ul>
+		<time>2021-03-04</time>
+		<p>Line one</p><p>line two</p>
+		<b class="stock">in stock: 12</b>`)}
+
+	product := &loadedProduct{}
+	item, err := res.Loader(product).
+		AddFromTags(Strip()).
+		AddCSS("price", ".price", ParseNumber()).
+		AddCSS("tags", "li", Strip()).
+		AddCSS("published", "time", ParseDate()).
+		AddCSS("Stock", ".stock", ParseNumber()).
+		AddCSS("Summary", "p").
+		Output("Summary", Join(" / ")).
+		Output("link", AbsoluteURL()).
+		Load()
+	if err != nil || item != product {
+		t.Fatalf("Load = %v, %v", item, err)
+	}
+	want := loadedProduct{
+		Title:     "Go  in Action",
+		Link:      "http://example.com/all",
+		Price:     1234.5,
+		Tags:      []string{"go", "books"},
+		Published: time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC),
+		Summary:   "Line one / line two",
+	}
+	if product.Stock == nil || *product.Stock != 12 {
+		t.Errorf("Stock = %v", product.Stock)
+	}
+	product.Stock = nil
+	if !reflect.DeepEqual(*product, want) {
+		t.Errorf("product = %+v", *product)
+	}
+
+	item, err = res.Loader(&loadedProduct{}).
+		AddCSS("title", "h1", Strip()).
+		AddCSS("price", "h1", ParseNumber()).
+		AddCSS("tags", "[", Strip()).
+		AddValue("missing", "x").
+		Load()
+	var fieldErrs FieldErrors
+	if !errors.As(err, &fieldErrs) || len(fieldErrs) != 3 || fieldErrs["title"] != nil {
+		t.Errorf("errors = %v", err)
+	}
+	if item.(*loadedProduct).Title != "Go  in Action" {
+		t.Error("fields without errors should still be loaded")
+	}
+
+	for value, ok := range map[float64]bool{4: true, 4.5: false, -1: true, 1e20: false} {
+		product := &loadedProduct{}
+		_, err := res.Loader(product).AddValue("Stock", value).Load()
+		if ok != (err == nil) || ok && *product.Stock != int(value) {
+			t.Errorf("Stock from %v = %v, %v", value, product.Stock, err)
+		}
+	}
+
+	jsonRes := &Response{URL: "http://example.com/api", Type: JSONContent, context: ctx,
+		Body: []byte(`{"name": "go", "stars": [5, 4], "score": 4.5}`)}
+	m := map[string]interface{}{}
+	if _, err := jsonRes.Loader(m).
+		AddJSONPath("name", "$.name").
+		AddJSONPath("stars", "$.stars[*]", ParseNumber()).
+		AddJSONPath("score", "$.score", ParseNumber()).
+		AddValue("source", "api").
+		Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, map[string]interface{}{"name": "go", "stars": []interface{}{int64(5), int64(4)}, "score": 4.5, "source": "api"}) {
+		t.Errorf("map item = %v", m)
+	}
+}
@@ -1,46 +1,196 @@
 package crawler
 
-// import "dcms"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+)
 
 // ItemPipelineFunc 处理item的函数
 type ItemPipelineFunc func(item interface{}, ctx *Context) interface{}
 
-// ItemPipeline pipeline接口
+// ItemProcessFunc 可以返回错误的item处理函数, 返回DropItem时丢弃item
+type ItemProcessFunc func(item interface{}, ctx *Context) (interface{}, error)
+
+// ItemPipeline pipeline接口, 返回的item非空时替换原item传给下一个pipeline, 为空时保留原item
 type ItemPipeline interface {
 	ProcessItem(item interface{}, ctx *Context) interface{}
 }
 
+// ItemPipelineE 可以返回错误的pipeline, 引擎调用ProcessItemE而不是ProcessItem
+//
+// 返回的item非空时替换原item传给下一个pipeline, 为空时保留原item;
+// 返回DropItem创建的错误时丢弃item, 返回其他错误时记为处理失败, 两种情况都不再执行后续pipeline和导出
+type ItemPipelineE interface {
+	ItemPipeline
+	ProcessItemE(item interface{}, ctx *Context) (interface{}, error)
+}
+
+// ItemCommitter 需要在item的最终结果确定后才提交的pipeline, 如只为导出的item记录去重指纹
+//
+// 引擎调用ProcessItemCommit而不是ProcessItemE, 返回的commit非空时, item通过所有pipeline并导出后以true调用,
+// 被之后的pipeline丢弃、处理失败或导出失败时以false调用
+type ItemCommitter interface {
+	ItemPipelineE
+	ProcessItemCommit(item interface{}, ctx *Context) (newItem interface{}, commit func(passed bool), err error)
+}
+
+// SpiderOpener 爬虫启动时需要初始化的pipeline, 返回错误时爬虫不会启动
+type SpiderOpener interface {
+	OpenSpider(ctx *Context) error
+}
+
+// SpiderCloser 爬虫结束时需要清理的pipeline, 在所有item处理完后调用
+type SpiderCloser interface {
+	CloseSpider(ctx *Context) error
+}
+
+// ItemErrorCallback item被丢弃或处理失败时的回调
+type ItemErrorCallback func(item interface{}, err error, ctx *Context)
+
+// DroppedItemError pipeline丢弃item时返回的错误
+type DroppedItemError struct {
+	Reason string
+	// Code 统计使用的原因, DropItem创建时为格式化前的reason, 为空时使用Reason
+	Code string
+	// Err 丢弃的具体原因, 如校验错误, 可为空
+	Err error
+}
+
+func (e *DroppedItemError) Error() string {
+	if e.Err != nil {
+		return "item dropped: " + e.Reason + ": " + e.Err.Error()
+	}
+	return "item dropped: " + e.Reason
+}
+
+func (e *DroppedItemError) Unwrap() error {
+	return e.Err
+}
+
+// DropItem 丢弃item, reason可以是格式字符串, 格式化后用于日志, 格式化前用于统计
+func DropItem(reason string, args ...interface{}) error {
+	err := &DroppedItemError{Reason: reason, Code: reason}
+	if len(args) > 0 {
+		err.Reason = fmt.Sprintf(reason, args...)
+	}
+	return err
+}
+
+// statsCode 统计使用的原因
+func (e *DroppedItemError) statsCode() string {
+	if e.Code != "" {
+		return e.Code
+	}
+	return e.Reason
+}
+
+// IsDropItem err是否为DropItem创建的错误
+func IsDropItem(err error) bool {
+	var dropped *DroppedItemError
+	return errors.As(err, &dropped)
+}
+
+// processItemE 以ItemPipeline的方式调用ItemPipelineE, 出错时输出日志并返回nil
+func processItemE(pipeline ItemPipelineE, item interface{}, ctx *Context) interface{} {
+	newItem, err := pipeline.ProcessItemE(item, ctx)
+	if err != nil {
+		log.Printf("process item failed in %s: %v", pipelineName(pipeline), err)
+		return nil
+	}
+	return newItem
+}
+
 type funcPipeline struct {
-	callback ItemPipelineFunc
+	callback ItemProcessFunc
 }
 
 func (cp *funcPipeline) ProcessItem(item interface{}, ctx *Context) interface{} {
+	return processItemE(cp, item, ctx)
+}
+
+func (cp *funcPipeline) ProcessItemE(item interface{}, ctx *Context) (interface{}, error) {
 	if cp.callback != nil {
 		return cp.callback(item, ctx)
 	}
-	return item
+	return item, nil
 }
 
 // MongoPipeline 默认mongodb pipeline
+//
+// Deprecated: 使用pipelines/mongo中的Pipeline. 导入pipelines/mongo后MongoPipeline转交给它写入, 否则item原样返回
 type MongoPipeline struct {
 	MongoDBURI string
 	Database   string
 	Collection string
+
+	once     sync.Once
+	pipeline ItemPipelineE
+}
+
+// mongoPipelineFactory pipelines/mongo注册的实现, crawler包因此不依赖MongoDB驱动
+var mongoPipelineFactory func(uri, database, collection string) ItemPipelineE
+
+// RegisterMongoPipeline 注册MongoPipeline使用的实现, 由pipelines/mongo在初始化时调用
+func RegisterMongoPipeline(factory func(uri, database, collection string) ItemPipelineE) {
+	mongoPipelineFactory = factory
+}
+
+func (dmp *MongoPipeline) target() ItemPipelineE {
+	dmp.once.Do(func() {
+		if mongoPipelineFactory == nil {
+			log.Println("MongoPipeline does nothing unless github.com/qhzhyt/go-crawler/pipelines/mongo is imported")
+			return
+		}
+		dmp.pipeline = mongoPipelineFactory(dmp.MongoDBURI, dmp.Database, dmp.Collection)
+	})
+	return dmp.pipeline
 }
 
 // ProcessItem 实现ItemPipeline接口
 func (dmp *MongoPipeline) ProcessItem(item interface{}, ctx *Context) interface{} {
-	// dcms.SaveItem(item)
-	return item
+	return processItemE(dmp, item, ctx)
 }
 
-// DefaultPipeLines 默认的pipelines
+// ProcessItemE 实现ItemPipelineE接口
+func (dmp *MongoPipeline) ProcessItemE(item interface{}, ctx *Context) (interface{}, error) {
+	if p := dmp.target(); p != nil {
+		return p.ProcessItemE(item, ctx)
+	}
+	return item, nil
+}
+
+// Close 写入缓存中的item并关闭连接
+func (dmp *MongoPipeline) Close() error {
+	if closer, ok := dmp.target().(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// DefaultPipeLines 默认的pipelines, 不包含任何pipeline; 写入MongoDB使用pipelines/mongo中的Pipeline
 func DefaultPipeLines() []ItemPipeline {
-	pipeline := MongoPipeline{}
-	return []ItemPipeline{&pipeline}
+	return []ItemPipeline{}
 }
 
-// FuncPipeline 仅提供一个函数的pipeline
+// FuncPipeline 仅提供一个函数的pipeline, 函数返回nil时保留原item
 func FuncPipeline(callback ItemPipelineFunc) ItemPipeline {
+	if callback == nil {
+		return &funcPipeline{}
+	}
+	return &funcPipeline{func(item interface{}, ctx *Context) (interface{}, error) {
+		return callback(item, ctx), nil
+	}}
+}
+
+// ProcessFuncPipeline 提供一个可以返回错误的函数的pipeline
+func ProcessFuncPipeline(callback ItemProcessFunc) ItemPipeline {
 	return &funcPipeline{callback}
 }
+
+// pipelineName pipeline的类型名, 用于日志和统计
+func pipelineName(pipeline ItemPipeline) string {
+	return fmt.Sprintf("%T", pipeline)
+}
@@ -0,0 +1,149 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestPipelineDropItem(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	var mutex sync.Mutex
+	var dropped, failed, exported []string
+	c := NewCrawler(&Settings{}).
+		ClearPipelines().
+		AddItemProcessFunc(func(item interface{}, ctx *Context) (interface{}, error) {
+			switch n := item.(int); n {
+			case 1:
+				return nil, DropItem("missing %s", "price")
+			case 2:
+				return nil, errors.New("database is down")
+			}
+			return nil, nil
+		}).
+		AddItemPipelineFunc(func(item interface{}, ctx *Context) interface{} {
+			mutex.Lock()
+			exported = append(exported, fmt.Sprint(item))
+			mutex.Unlock()
+			return nil
+		}).
+		OnItemDropped(func(item interface{}, err error, ctx *Context) {
+			mutex.Lock()
+			dropped = append(dropped, fmt.Sprint(item, ": ", err))
+			mutex.Unlock()
+		}).
+		OnItemError(func(item interface{}, err error, ctx *Context) {
+			mutex.Lock()
+			failed = append(failed, fmt.Sprint(item, ": ", err))
+			mutex.Unlock()
+		}).
+		OnResponse(func(res *Response, ctx *Context) {
+			for i := 0; i < 3; i++ {
+				ctx.Emit(i)
+			}
+		}).
+		WithStartRequests(func(ctx *Context) []*Request {
+			return []*Request{GetURL(server.URL)}
+		}).
+		Start(true)
+
+	mutex.Lock()
+	if fmt.Sprint(exported) != "[0]" {
+		t.Errorf("exported = %v", exported)
+	}
+	if fmt.Sprint(dropped) != "[1: item dropped: missing price]" {
+		t.Errorf("dropped = %v", dropped)
+	}
+	if fmt.Sprint(failed) != "[2: database is down]" {
+		t.Errorf("failed = %v", failed)
+	}
+	mutex.Unlock()
+	stats := c.Stats()
+	if stats.Get("item/scraped_count") != 1 || stats.Get("item/dropped_count") != 1 ||
+		stats.Get("item/dropped_reasons_count/missing %s") != 1 || stats.Get("item/error_count") != 1 ||
+		stats.Get("item/error_count/*crawler.funcPipeline") != 1 {
+		t.Errorf("stats = %v", stats.Values())
+	}
+	if item, err := (&MongoPipeline{}).ProcessItemE(1, nil); item != 1 || err != nil {
+		t.Errorf("MongoPipeline without pipelines/mongo = %v, %v", item, err)
+	}
+	if !IsDropItem(fmt.Errorf("wrapped: %w", DropItem("dup"))) || IsDropItem(errors.New("dup")) {
+		t.Error("IsDropItem should unwrap errors")
+	}
+}
+
+type lifecyclePipeline struct {
+	name    string
+	openErr error
+	events  *[]string
+	mutex   *sync.Mutex
+}
+
+func (p *lifecyclePipeline) record(event string) {
+	p.mutex.Lock()
+	*p.events = append(*p.events, p.name+":"+event)
+	p.mutex.Unlock()
+}
+
+func (p *lifecyclePipeline) OpenSpider(ctx *Context) error {
+	p.record("open")
+	return p.openErr
+}
+
+func (p *lifecyclePipeline) ProcessItem(item interface{}, ctx *Context) interface{} {
+	p.record(fmt.Sprint(item))
+	return item
+}
+
+func (p *lifecyclePipeline) CloseSpider(ctx *Context) error {
+	p.record("close")
+	return nil
+}
+
+func TestPipelineLifecycle(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	var mutex sync.Mutex
+	var events []string
+	crawl := func(openErr error) *Crawler {
+		events = nil
+		return NewCrawler(&Settings{}).
+			ClearPipelines().
+			AddItemPipeline(&lifecyclePipeline{name: "a", events: &events, mutex: &mutex}).
+			AddItemPipeline(&lifecyclePipeline{name: "b", openErr: openErr, events: &events, mutex: &mutex}).
+			OnResponse(func(res *Response, ctx *Context) {
+				ctx.Emit(1)
+			}).
+			WithStartRequests(func(ctx *Context) []*Request {
+				return []*Request{GetURL(server.URL)}
+			}).
+			Start(true)
+	}
+
+	c := crawl(nil)
+	if fmt.Sprint(events) != "[a:open b:open a:1 b:1 a:close b:close]" || c.Err() != nil {
+		t.Errorf("events = %v, err = %v", events, c.Err())
+	}
+
+	c = crawl(errors.New("no database"))
+	if fmt.Sprint(events) != "[a:open b:open a:close]" || c.Err() == nil || requests != 1 {
+		t.Errorf("events = %v, err = %v, requests = %d", events, c.Err(), requests)
+	}
+	c.Wait()
+	c.Stop()
+	if len(events) != 3 {
+		t.Errorf("Stop after a failed start closed pipelines again: %v", events)
+	}
+}
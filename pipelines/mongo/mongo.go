@@ -0,0 +1,307 @@
+// Package mongo 将item写入MongoDB的pipeline
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	crawler "github.com/qhzhyt/go-crawler"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	driver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DocumentStore Pipeline写入的文档存储
+//
+// 写入可能部分成功后返回错误, Pipeline会重试整批, 因此实现需要忽略_id重复的文档
+type DocumentStore interface {
+	// InsertMany 批量插入
+	InsertMany(ctx context.Context, docs []bson.D) error
+	// UpsertMany 按key字段批量替换, 不存在时插入
+	UpsertMany(ctx context.Context, key string, docs []bson.D) error
+	Close(ctx context.Context) error
+}
+
+// Pipeline 将item批量写入MongoDB, 设置KeyField时按该字段upsert;
+// item按bson规则编码, 结构体可使用bson标签. 通过Crawler.AddItemPipeline添加
+type Pipeline struct {
+	MongoDBURI string
+	Database   string
+	Collection string
+	// KeyField upsert使用的字段, 为空时直接插入
+	KeyField string
+	// BatchSize 每批写入的item数, 默认100
+	BatchSize int
+	// MaxRetries 写入失败后的重试次数, 网络错误时会重新连接, 默认3
+	MaxRetries int
+	// Timeout 每次写入的超时时间, 默认30秒
+	Timeout time.Duration
+	// MaxBufferSize 写入失败时缓存中最多保留的item数, 超出时丢弃最早的item, 默认为BatchSize的10倍
+	MaxBufferSize int
+	// Store 文档存储, 为空时连接MongoDBURI
+	Store DocumentStore
+
+	mutex sync.Mutex
+	// writeMutex 保证同一时间只有一批在写入, 写入时不持有mutex, 不阻塞其他item进入缓存
+	writeMutex sync.Mutex
+	buffer     []bson.D
+}
+
+func init() {
+	crawler.RegisterMongoPipeline(func(uri, database, collection string) crawler.ItemPipelineE {
+		return NewPipeline(uri, database, collection)
+	})
+}
+
+// NewPipeline 创建Pipeline
+func NewPipeline(uri, database, collection string) *Pipeline {
+	return &Pipeline{MongoDBURI: uri, Database: database, Collection: collection}
+}
+
+// ProcessItem 实现crawler.ItemPipeline接口, 出错时输出日志并返回nil
+func (p *Pipeline) ProcessItem(item interface{}, ctx *crawler.Context) interface{} {
+	newItem, err := p.ProcessItemE(item, ctx)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	return newItem
+}
+
+// ProcessItemE 实现crawler.ItemPipelineE接口, 缓存item, 满一批时写入;
+// 写入失败不影响当前item, 只输出日志并计入mongo/write_failed_count, 丢弃的item计入mongo/discarded_count
+func (p *Pipeline) ProcessItemE(item interface{}, ctx *crawler.Context) (interface{}, error) {
+	doc, err := p.toDocument(item)
+	if err != nil {
+		return nil, fmt.Errorf("mongo pipeline: encode item failed: %w", err)
+	}
+	p.mutex.Lock()
+	p.buffer = append(p.buffer, doc)
+	full := len(p.buffer) >= p.batchSize()
+	p.mutex.Unlock()
+	if full {
+		if discarded, err := p.flush(); err != nil {
+			log.Printf("mongo pipeline: %v", err)
+			if ctx != nil && ctx.Engine != nil {
+				ctx.Engine.Stats.Inc("mongo/write_failed_count")
+				ctx.Engine.Stats.Add("mongo/discarded_count", int64(discarded))
+			}
+		}
+	}
+	return item, nil
+}
+
+// Flush 写入缓存中的item, 失败时item留在缓存中, 下次Flush时再写入;
+// 缓存超过MaxBufferSize时丢弃最早的item, 丢弃的数量计入返回的错误
+func (p *Pipeline) Flush() error {
+	_, err := p.flush()
+	return err
+}
+
+// flush 同Flush, 同时返回丢弃的item数
+func (p *Pipeline) flush() (int, error) {
+	p.writeMutex.Lock()
+	defer p.writeMutex.Unlock()
+	p.mutex.Lock()
+	docs := p.buffer
+	p.buffer = nil
+	p.mutex.Unlock()
+	if len(docs) == 0 {
+		return 0, nil
+	}
+	if err := p.write(docs); err != nil {
+		p.mutex.Lock()
+		p.buffer = append(docs, p.buffer...)
+		discarded := len(p.buffer) - p.maxBufferSize()
+		if discarded > 0 {
+			p.buffer = append([]bson.D(nil), p.buffer[discarded:]...)
+		}
+		p.mutex.Unlock()
+		if discarded > 0 {
+			return discarded, fmt.Errorf("%w, discarded %d oldest documents", err, discarded)
+		}
+		return 0, err
+	}
+	return 0, nil
+}
+
+// Close 写入缓存中的item并关闭连接, 仍未写入的item数计入返回的错误
+func (p *Pipeline) Close() error {
+	err := p.Flush()
+	p.writeMutex.Lock()
+	defer p.writeMutex.Unlock()
+	if p.Store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), p.timeout())
+		defer cancel()
+		if closeErr := p.Store.Close(ctx); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (p *Pipeline) batchSize() int {
+	if p.BatchSize > 0 {
+		return p.BatchSize
+	}
+	return 100
+}
+
+func (p *Pipeline) maxBufferSize() int {
+	if p.MaxBufferSize > 0 {
+		return p.MaxBufferSize
+	}
+	return p.batchSize() * 10
+}
+
+func (p *Pipeline) timeout() time.Duration {
+	if p.Timeout > 0 {
+		return p.Timeout
+	}
+	return 30 * time.Second
+}
+
+// write 写入一批, 失败时重试; 调用者须持有writeMutex
+func (p *Pipeline) write(docs []bson.D) error {
+	if p.Store == nil {
+		p.Store = &mongoStore{uri: p.MongoDBURI, database: p.Database, collection: p.Collection}
+	}
+	retries := p.MaxRetries
+	if retries <= 0 {
+		retries = 3
+	}
+	var err error
+	for i := 0; i <= retries; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i) * 500 * time.Millisecond)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), p.timeout())
+		if p.KeyField != "" {
+			err = p.Store.UpsertMany(ctx, p.KeyField, docs)
+		} else {
+			err = p.Store.InsertMany(ctx, docs)
+		}
+		cancel()
+		if err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("write %d documents failed, kept for the next flush: %w", len(docs), err)
+}
+
+// toDocument 将item编码为bson文档; 会被插入的文档没有_id时生成_id, 重试时不会重复插入
+func (p *Pipeline) toDocument(item interface{}) (bson.D, error) {
+	doc, ok := item.(bson.D)
+	if !ok {
+		data, err := bson.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		if err = bson.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := documentKey(doc, "_id"); ok {
+		return doc, nil
+	}
+	if _, ok := documentKey(doc, p.KeyField); p.KeyField != "" && ok {
+		return doc, nil
+	}
+	return append(bson.D{{Key: "_id", Value: primitive.NewObjectID()}}, doc...), nil
+}
+
+// documentKey 文档中key字段的值
+func documentKey(doc bson.D, key string) (interface{}, bool) {
+	for _, e := range doc {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ignoreDuplicateIDs 忽略_id重复的写入错误, 这些文档已在之前部分成功的写入中插入
+func ignoreDuplicateIDs(err error) error {
+	var bulkErr driver.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil || len(bulkErr.WriteErrors) == 0 {
+		return err
+	}
+	for _, e := range bulkErr.WriteErrors {
+		if e.Code != 11000 || !strings.Contains(e.Message, "index: _id_ ") {
+			return err
+		}
+	}
+	return nil
+}
+
+// mongoStore 基于mongo-driver的DocumentStore, 首次写入时连接, 网络错误后重新连接
+type mongoStore struct {
+	uri, database, collection string
+	client                    *driver.Client
+}
+
+func (s *mongoStore) coll(ctx context.Context) (*driver.Collection, error) {
+	if s.client == nil {
+		client, err := driver.Connect(ctx, options.Client().ApplyURI(s.uri))
+		if err != nil {
+			return nil, err
+		}
+		s.client = client
+	}
+	return s.client.Database(s.database).Collection(s.collection), nil
+}
+
+// checkConnection 网络错误时断开连接, 下次写入时重新连接
+func (s *mongoStore) checkConnection(ctx context.Context, err error) error {
+	if err != nil && s.client != nil && (driver.IsNetworkError(err) || errors.Is(err, driver.ErrClientDisconnected)) {
+		s.client.Disconnect(ctx)
+		s.client = nil
+	}
+	return err
+}
+
+func (s *mongoStore) InsertMany(ctx context.Context, docs []bson.D) error {
+	coll, err := s.coll(ctx)
+	if err != nil {
+		return err
+	}
+	values := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		values[i] = doc
+	}
+	_, err = coll.InsertMany(ctx, values, options.InsertMany().SetOrdered(false))
+	return s.checkConnection(ctx, ignoreDuplicateIDs(err))
+}
+
+func (s *mongoStore) UpsertMany(ctx context.Context, key string, docs []bson.D) error {
+	coll, err := s.coll(ctx)
+	if err != nil {
+		return err
+	}
+	models := make([]driver.WriteModel, len(docs))
+	for i, doc := range docs {
+		value, ok := documentKey(doc, key)
+		if !ok {
+			models[i] = driver.NewInsertOneModel().SetDocument(doc)
+			continue
+		}
+		models[i] = driver.NewReplaceOneModel().SetFilter(bson.D{{Key: key, Value: value}}).SetReplacement(doc).SetUpsert(true)
+	}
+	_, err = coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return s.checkConnection(ctx, ignoreDuplicateIDs(err))
+}
+
+func (s *mongoStore) Close(ctx context.Context) error {
+	if s.client == nil {
+		return nil
+	}
+	err := s.client.Disconnect(ctx)
+	s.client = nil
+	return err
+}
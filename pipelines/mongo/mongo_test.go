@@ -0,0 +1,149 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	crawler "github.com/qhzhyt/go-crawler"
+	"go.mongodb.org/mongo-driver/bson"
+	driver "go.mongodb.org/mongo-driver/mongo"
+)
+
+// memoryStore 内存中的DocumentStore, 与MongoDB一样忽略_id重复的文档
+type memoryStore struct {
+	mutex sync.Mutex
+	docs  []bson.D
+	// failures 之后的写入次数内只写入第一个文档并返回错误
+	failures int
+}
+
+func (s *memoryStore) fail(docs []bson.D) ([]bson.D, error) {
+	if s.failures > 0 {
+		s.failures--
+		return docs[:1], errors.New("connection reset")
+	}
+	return docs, nil
+}
+
+func (s *memoryStore) InsertMany(ctx context.Context, docs []bson.D) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	docs, err := s.fail(docs)
+	for _, doc := range docs {
+		if s.find("_id", doc) < 0 {
+			s.docs = append(s.docs, doc)
+		}
+	}
+	return err
+}
+
+func (s *memoryStore) UpsertMany(ctx context.Context, key string, docs []bson.D) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	docs, err := s.fail(docs)
+	for _, doc := range docs {
+		if i := s.find(key, doc); i >= 0 {
+			s.docs[i] = doc
+		} else if s.find("_id", doc) < 0 {
+			s.docs = append(s.docs, doc)
+		}
+	}
+	return err
+}
+
+func (s *memoryStore) find(key string, doc bson.D) int {
+	value, ok := documentKey(doc, key)
+	if !ok {
+		return -1
+	}
+	for i, old := range s.docs {
+		if oldValue, found := documentKey(old, key); found && reflect.DeepEqual(oldValue, value) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *memoryStore) Close(ctx context.Context) error {
+	return nil
+}
+
+func (s *memoryStore) documents() []bson.D {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]bson.D(nil), s.docs...)
+}
+
+type mongoTestItem struct {
+	URL   string `bson:"url"`
+	Title string `bson:"title"`
+}
+
+func TestPipeline(t *testing.T) {
+	store := &memoryStore{}
+	pipeline := &Pipeline{Store: store, KeyField: "url", BatchSize: 2}
+	pipeline.ProcessItemE(&mongoTestItem{URL: "/a", Title: "A"}, nil)
+	if len(store.documents()) != 0 {
+		t.Error("items should be buffered until the batch is full")
+	}
+	pipeline.ProcessItemE(map[string]string{"url": "/b", "title": "B"}, nil)
+	pipeline.ProcessItemE(&mongoTestItem{URL: "/a", Title: "A2"}, nil)
+	if err := pipeline.Close(); err != nil {
+		t.Fatal(err)
+	}
+	docs := store.documents()
+	if len(docs) != 2 {
+		t.Fatalf("documents = %v", docs)
+	}
+	if title, _ := documentKey(docs[0], "title"); title != "A2" {
+		t.Errorf("upserted document = %v", docs[0])
+	}
+	if url, _ := documentKey(docs[1], "url"); url != "/b" {
+		t.Errorf("document = %v", docs[1])
+	}
+}
+
+func TestPipelineWriteFailure(t *testing.T) {
+	stats := crawler.NewStats()
+	ctx := &crawler.Context{Engine: &crawler.CrawlEngine{Stats: stats}}
+	store := &memoryStore{failures: 2}
+	pipeline := &Pipeline{Store: store, BatchSize: 2, MaxRetries: 1}
+	pipeline.ProcessItemE(bson.D{{Key: "n", Value: 1}}, ctx)
+	if item, err := pipeline.ProcessItemE(bson.D{{Key: "n", Value: 2}}, ctx); err != nil || item == nil {
+		t.Errorf("write errors should not fail the current item: %v, %v", item, err)
+	}
+	if len(pipeline.buffer) != 2 || stats.Get("mongo/write_failed_count") != 1 {
+		t.Errorf("failed documents should stay buffered, got %d, stats = %v", len(pipeline.buffer), stats.Values())
+	}
+	if err := pipeline.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if docs := store.documents(); len(docs) != 2 {
+		t.Errorf("partial writes should not duplicate documents: %v", docs)
+	}
+
+	pipeline = &Pipeline{Store: &memoryStore{failures: 100}, BatchSize: 2, MaxRetries: 1, MaxBufferSize: 3}
+	for i := 0; i < 4; i++ {
+		pipeline.ProcessItemE(bson.D{{Key: "n", Value: i}}, ctx)
+	}
+	if len(pipeline.buffer) != 3 || stats.Get("mongo/discarded_count") != 1 {
+		t.Errorf("buffer should be capped, got %d, stats = %v", len(pipeline.buffer), stats.Values())
+	}
+	if n, _ := documentKey(pipeline.buffer[0], "n"); n != 1 {
+		t.Errorf("oldest document should be discarded first: %v", pipeline.buffer)
+	}
+}
+
+func TestIgnoreDuplicateIDs(t *testing.T) {
+	dup := driver.BulkWriteException{WriteErrors: []driver.BulkWriteError{{WriteError: driver.WriteError{Code: 11000, Message: "E11000 duplicate key error collection: test.items index: _id_ dup key: { _id: 1 }"}}}}
+	if err := ignoreDuplicateIDs(dup); err != nil {
+		t.Errorf("duplicate _id should be ignored: %v", err)
+	}
+	other := driver.BulkWriteException{WriteErrors: []driver.BulkWriteError{{WriteError: driver.WriteError{Code: 11000, Message: "E11000 duplicate key error collection: test.items index: url_1 dup key: { url: \"/a\" }"}}}}
+	if err := ignoreDuplicateIDs(other); err == nil {
+		t.Error("duplicate key on other index should be reported")
+	}
+}
@@ -0,0 +1,196 @@
+package crawler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Processor ItemLoader的字段处理器, 输入和输出都是字段的值列表, 可以依次组合
+type Processor func(values []interface{}, loader *ItemLoader) ([]interface{}, error)
+
+// Compose 依次执行多个处理器
+func Compose(processors ...Processor) Processor {
+	return func(values []interface{}, loader *ItemLoader) ([]interface{}, error) {
+		var err error
+		for _, p := range processors {
+			if values, err = p(values, loader); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+}
+
+// MapString 对每个字符串值执行f, 其他类型的值保持不变
+func MapString(f func(s string) string) Processor {
+	return func(values []interface{}, loader *ItemLoader) ([]interface{}, error) {
+		result := make([]interface{}, len(values))
+		for i, v := range values {
+			if s, ok := v.(string); ok {
+				v = f(s)
+			}
+			result[i] = v
+		}
+		return result, nil
+	}
+}
+
+// Strip 去掉字符串首尾的空白, 并去掉空字符串
+func Strip() Processor {
+	return func(values []interface{}, loader *ItemLoader) ([]interface{}, error) {
+		result := make([]interface{}, 0, len(values))
+		for _, v := range values {
+			if s, ok := v.(string); ok {
+				if s = strings.TrimSpace(s); s == "" {
+					continue
+				}
+				v = s
+			}
+			result = append(result, v)
+		}
+		return result, nil
+	}
+}
+
+// Join 将所有值用sep连接为一个字符串
+func Join(sep string) Processor {
+	return func(values []interface{}, loader *ItemLoader) ([]interface{}, error) {
+		texts := make([]string, len(values))
+		for i, v := range values {
+			texts[i] = valueString(v)
+		}
+		return []interface{}{strings.Join(texts, sep)}, nil
+	}
+}
+
+// TakeFirst 只保留第一个非空值
+func TakeFirst() Processor {
+	return func(values []interface{}, loader *ItemLoader) ([]interface{}, error) {
+		for _, v := range values {
+			if v != nil && v != "" {
+				return []interface{}{v}, nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+var numberPattern = regexp.MustCompile(`[-+]?\d[\d,]*(?:\.\d+)?|[-+]?\.\d+`)
+
+// ParseNumber 从字符串中提取第一个数字, 如"$1,234.50"提取为1234.5; 整数为int64, 小数为float64
+func ParseNumber() Processor {
+	return func(values []interface{}, loader *ItemLoader) ([]interface{}, error) {
+		result := make([]interface{}, len(values))
+		for i, v := range values {
+			var text string
+			switch v := v.(type) {
+			case string:
+				text = numberPattern.FindString(v)
+				if text == "" {
+					return nil, fmt.Errorf("no number in %q", v)
+				}
+				text = strings.Replace(text, ",", "", -1)
+			case json.Number:
+				text = v.String()
+			default:
+				result[i] = v
+				continue
+			}
+			if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+				result[i] = n
+				continue
+			}
+			f, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = f
+		}
+		return result, nil
+	}
+}
+
+// defaultDateLayouts ParseDate未指定格式时尝试的格式
+var defaultDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/01/02 15:04:05",
+	"2006/01/02",
+	"2006年1月2日",
+	time.RFC1123Z,
+	time.RFC1123,
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+}
+
+// ParseDate 按layouts依次解析字符串为time.Time, layouts为空时尝试常见的日期格式
+func ParseDate(layouts ...string) Processor {
+	if len(layouts) == 0 {
+		layouts = defaultDateLayouts
+	}
+	return func(values []interface{}, loader *ItemLoader) ([]interface{}, error) {
+		result := make([]interface{}, len(values))
+		for i, v := range values {
+			s, ok := v.(string)
+			if !ok {
+				result[i] = v
+				continue
+			}
+			s = strings.TrimSpace(s)
+			var parsed bool
+			for _, layout := range layouts {
+				if t, err := time.Parse(layout, s); err == nil {
+					result[i], parsed = t, true
+					break
+				}
+			}
+			if !parsed {
+				return nil, fmt.Errorf("cannot parse date %q", s)
+			}
+		}
+		return result, nil
+	}
+}
+
+// AbsoluteURL 将相对链接转为绝对链接, 相对于loader.BaseURL
+func AbsoluteURL() Processor {
+	return func(values []interface{}, loader *ItemLoader) ([]interface{}, error) {
+		base, err := url.Parse(loader.BaseURL)
+		if err != nil {
+			return nil, err
+		}
+		result := make([]interface{}, len(values))
+		for i, v := range values {
+			if s, ok := v.(string); ok {
+				ref, err := url.Parse(strings.TrimSpace(s))
+				if err != nil {
+					return nil, err
+				}
+				v = base.ResolveReference(ref).String()
+			}
+			result[i] = v
+		}
+		return result, nil
+	}
+}
+
+// valueString 值的字符串形式
+func valueString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	return fmt.Sprint(v)
+}
@@ -35,13 +35,26 @@ type Request struct {
 	Meta          Meta
 	Callback      ResponseCallback
 	ErrorCallback RequestErrorCallback
-	context       *Context
-	ProxyURL      string
-	OriginURL     string
-	Host          string
-	History       History
-	retryTimes    int
-	redirectTimes int
+	// UnchangedCallback 增量爬取时页面未变化的回调
+	UnchangedCallback ResponseCallback
+	context           *Context
+	ProxyURL          string
+	OriginURL         string
+	Host              string
+	History           History
+	// Encoding 指定响应编码, 为空时自动检测
+	Encoding string
+	// MaxResponseSize 覆盖Settings.MaxResponseSize, 小于0表示不限制
+	MaxResponseSize int64
+	// WarnResponseSize 覆盖Settings.WarnResponseSize, 小于0表示不警告
+	WarnResponseSize int64
+	// StreamCallback 设置后不读取body, 以流的方式交给回调处理
+	StreamCallback StreamCallback
+	// SaveBodyToFile 将body写入DownloadDir下的临时文件, 路径见Response.BodyFile
+	SaveBodyToFile bool
+	DownloadDir    string
+	retryTimes     int
+	redirectTimes  int
 }
 
 // Args is http post form
@@ -71,7 +84,14 @@ func (req *Request) toHTTPRequest() (*http.Request, error) {
 	//		}
 	//	}
 	//}
-	result.Header = req.Headers
+	// 复制请求头, 以免修改req.Headers或在其为nil时panic
+	result.Header = req.Headers.Clone()
+	if result.Header == nil {
+		result.Header = http.Header{}
+	}
+	if result.Header.Get("Accept-Encoding") == "" {
+		result.Header.Set("Accept-Encoding", acceptEncoding())
+	}
 
 	for k, v := range req.Cookies {
 		if v != "" {
@@ -185,6 +205,37 @@ func (req *Request) WithHost(host string) *Request {
 	return req
 }
 
+// WithEncoding set response encoding, overrides automatic detection
+func (req *Request) WithEncoding(encoding string) *Request {
+	req.Encoding = encoding
+	return req
+}
+
+// WithMaxResponseSize set max response size in bytes, negative means no limit
+func (req *Request) WithMaxResponseSize(size int64) *Request {
+	req.MaxResponseSize = size
+	return req
+}
+
+// WithWarnResponseSize set warn response size in bytes, negative means no warning
+func (req *Request) WithWarnResponseSize(size int64) *Request {
+	req.WarnResponseSize = size
+	return req
+}
+
+// OnStream set stream callback, the body is passed to callback as io.Reader without buffering
+func (req *Request) OnStream(callback StreamCallback) *Request {
+	req.StreamCallback = callback
+	return req
+}
+
+// ToTempFile write body to a temp file in dir instead of memory, the default temp dir is used if dir is empty
+func (req *Request) ToTempFile(dir string) *Request {
+	req.SaveBodyToFile = true
+	req.DownloadDir = dir
+	return req
+}
+
 // WithCookies set Cookies
 func (req *Request) WithCookies(cookies map[string]string) *Request {
 	for k, v := range cookies {
@@ -204,21 +255,34 @@ func (req *Request) OnError(callback RequestErrorCallback) *Request {
 	return req
 }
 
+// OnUnchanged set callback for page unchanged since last crawl
+func (req *Request) OnUnchanged(callback ResponseCallback) *Request {
+	req.UnchangedCallback = callback
+	return req
+}
+
 func (req *Request) Clone() *Request {
 	return &Request{
-		Method:        req.Method,
-		URL:           req.URL,
-		Headers:       req.Headers,
-		Cookies:       req.Cookies,
-		Body:          req.Body,
-		Timeout:       req.Timeout,
-		Callback:      req.Callback,
-		ErrorCallback: req.ErrorCallback,
-		Meta:          req.Meta,
-		ProxyURL:      req.ProxyURL,
-		OriginURL:     req.OriginURL,
-		context:       req.context,
-		redirectTimes: req.redirectTimes,
+		Method:            req.Method,
+		URL:               req.URL,
+		Headers:           req.Headers,
+		Cookies:           req.Cookies,
+		Body:              req.Body,
+		Timeout:           req.Timeout,
+		Callback:          req.Callback,
+		ErrorCallback:     req.ErrorCallback,
+		UnchangedCallback: req.UnchangedCallback,
+		Meta:              req.Meta,
+		ProxyURL:          req.ProxyURL,
+		Encoding:          req.Encoding,
+		MaxResponseSize:   req.MaxResponseSize,
+		WarnResponseSize:  req.WarnResponseSize,
+		StreamCallback:    req.StreamCallback,
+		SaveBodyToFile:    req.SaveBodyToFile,
+		DownloadDir:       req.DownloadDir,
+		OriginURL:         req.OriginURL,
+		context:           req.context,
+		redirectTimes:     req.redirectTimes,
 	}
 }
 
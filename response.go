@@ -2,14 +2,17 @@ package crawler
 
 import (
 	//"bytes"
-	"compress/gzip"
 	"crypto/x509"
+	"encoding/json"
+	"fmt"
 	"github.com/qhzhyt/go-crawler/htmlquery"
+	"github.com/qhzhyt/go-crawler/jsonquery"
 	//"golang.org/x/net/html/charset"
 	"io"
 	"io/ioutil"
+	"log"
 	"net/http"
-	"strings"
+	"sync"
 )
 
 // Cookies Cookies
@@ -26,6 +29,7 @@ type History []*HistoryItem
 
 // Response Crawler的响应
 type Response struct {
+	// Selector 解析后的HTML/XML文档, Settings.AutoParseHtml为true时在收到响应后解析, 否则在首次选择时解析
 	*htmlquery.Selector
 	StatusCode int
 	URL        string
@@ -37,6 +41,21 @@ type Response struct {
 	Meta       Meta
 	context    *Context
 	History    History
+	// Unchanged 增量爬取时页面自上次爬取以来未变化
+	Unchanged bool
+	// Encoding 响应原始编码, 文本类响应的Body已转换为UTF-8
+	Encoding string
+	// DecodeError 转换为UTF-8失败时的错误, 此时Body保持原样, 并计入response/decode_failed_count
+	DecodeError error
+	// BodyFile 使用Request.ToTempFile时保存body的临时文件, 由使用者负责删除
+	BodyFile string
+	// Type 根据Content-Type和内容判断的响应类型
+	Type      ContentType
+	parseOnce sync.Once
+	parseErr  error
+	jsonDoc   *jsonquery.Selector
+	jsonOnce  sync.Once
+	jsonErr   error
 	//NativeResponse  *http.Response
 	X509Certificate *x509.Certificate
 	X509CertChan    []*x509.Certificate
@@ -46,32 +65,39 @@ type Response struct {
 // func NewResponse(content []byte) *Response {
 // 	return &Response{Selector: htmlquery.NewSelector(content), Body: content}
 // }
-// NewResponse create a Response from http.Response
+// NewResponse create a Response from http.Response, 读取body失败时返回不含body的Response
 func NewResponse(res *http.Response) *Response {
+	response, err := ReadResponse(res)
+	if err != nil {
+		log.Printf("read response from %s failed: %v", res.Request.URL, err)
+		return newResponse(res)
+	}
+	return response
+}
+
+// ReadResponse 读取http.Response的body并创建Response, 返回解压或读取body的错误
+func ReadResponse(res *http.Response) (*Response, error) {
 	defer res.Body.Close()
 	//res.Request.Body.Close()
 	//content, _ := ioutil.ReadAll(res.Body)
-	var err error
-
-	var bodyReader io.Reader = res.Body
-	//if bodySize > 0 {
-	//	bodyReader = io.LimitReader(bodyReader, int64(bodySize))
-	//}
-	contentEncoding := strings.ToLower(res.Header.Get("Content-Encoding"))
-	if !res.Uncompressed && (strings.Contains(contentEncoding, "gzip") ||
-		(contentEncoding == "" && strings.Contains(strings.ToLower(res.Header.Get("Content-Type")), "gzip"))) {
-		bodyReader, err = gzip.NewReader(bodyReader)
-		if err != nil {
-			bodyReader = res.Body
-		} else {
-			defer bodyReader.(*gzip.Reader).Close()
-		}
+	bodyReader, err := openBody(res)
+	if err != nil {
+		return nil, err
 	}
+	defer bodyReader.Close()
 	body, err := ioutil.ReadAll(bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	response := newResponse(res)
+	response.Body = body
+	return response, nil
+}
 
+// newResponse 创建不含body的Response
+func newResponse(res *http.Response) *Response {
 	response := &Response{
 		//Selector:       htmlquery.NewSelector(body2),
-		Body:       body,
 		Headers:    res.Header,
 		Status:     res.Status,
 		StatusCode: res.StatusCode,
@@ -79,16 +105,6 @@ func NewResponse(res *http.Response) *Response {
 		History:    History{},
 	}
 
-	//res.
-	//if len(body) > 0 {
-	//	body := bytes.NewReader(content)
-	//	r, err := charset.NewReader(body, res.Header.Get("Content-Type"))
-	//	if err != nil {
-	//		return &Response{Body: content}
-	//	}
-	//	body2, _ := ioutil.ReadAll(r)
-	//	response.Selector = htmlquery.NewSelector(body2)
-	//}
 	cookies := make(Cookies)
 	for _, cookie := range res.Cookies() {
 		cookies[cookie.Name] = cookie.Value
@@ -107,18 +123,151 @@ func NewResponse(res *http.Response) *Response {
 	return response
 }
 
+// openBody 返回解压后的body
+func openBody(res *http.Response) (io.ReadCloser, error) {
+	if contentEncoding := res.Header.Get("Content-Encoding"); !res.Uncompressed && contentEncoding != "" && hasBody(res) {
+		return decodeContent(res.Body, contentEncoding)
+	}
+	return ioutil.NopCloser(res.Body), nil
+}
+
+// hasBody 判断响应是否可能包含body
+func hasBody(res *http.Response) bool {
+	if res.StatusCode == http.StatusNoContent || res.StatusCode == http.StatusNotModified || res.ContentLength == 0 {
+		return false
+	}
+	return res.Request == nil || res.Request.Method != http.MethodHead
+}
+
 // WithRequest 设置request
 func (res *Response) WithRequest(req *Request) *Response {
 	res.Request = req
 	res.Meta = req.Meta
 	res.context = req.context
 	res.History = req.History
-	if res.context.Settings.AutoParseHtml {
-		res.Selector = htmlquery.NewSelector(res.Body)
+	res.Type = detectContentType(res.Headers.Get("Content-Type"), res.Body)
+	if len(res.Body) > 0 && res.Type.IsText() {
+		body, encoding, err := decodeToUTF8(res.Body, res.Headers.Get("Content-Type"), req.Encoding)
+		if err != nil {
+			res.DecodeError = fmt.Errorf("decode %s from %s failed: %w", res.URL, encoding, err)
+			log.Println(res.DecodeError)
+			if res.context != nil && res.context.Engine != nil {
+				res.context.Engine.Stats.Inc("response/decode_failed_count")
+			}
+		} else {
+			res.Body = body
+		}
+		res.Encoding = encoding
+	}
+	if res.context != nil && res.context.Settings.AutoParseHtml && (res.Type == HTMLContent || res.Type == XMLContent) {
+		if _, err := res.Document(); err != nil {
+			log.Println(err)
+		}
 	}
 	return res
 }
 
+// ContentTypeError 响应类型不支持当前操作
+type ContentTypeError struct {
+	URL  string
+	Type ContentType
+	Want string
+}
+
+func (e *ContentTypeError) Error() string {
+	return fmt.Sprintf("%s response from %s cannot be parsed as %s", e.Type, e.URL, e.Want)
+}
+
+// Document 返回解析后的HTML/XML文档, 尚未解析时解析并保存到res.Selector
+func (res *Response) Document() (*htmlquery.Selector, error) {
+	res.parseOnce.Do(func() {
+		if res.Selector != nil {
+			return
+		}
+		if res.Type != HTMLContent && res.Type != XMLContent {
+			res.parseErr = &ContentTypeError{URL: res.URL, Type: res.Type, Want: "html"}
+		} else if res.Selector, res.parseErr = htmlquery.ParseSelector(res.Body); res.parseErr != nil {
+			res.Selector = nil
+			res.parseErr = fmt.Errorf("parse html from %s failed: %w", res.URL, res.parseErr)
+		}
+	})
+	return res.Selector, res.parseErr
+}
+
+// CSS 通过CSS选择节点, 非HTML/XML响应返回空结果
+func (res *Response) CSS(css string) htmlquery.Selectors {
+	doc, err := res.Document()
+	if err != nil {
+		return htmlquery.Selectors{}
+	}
+	return doc.CSS(css)
+}
+
+// Xpath 通过Xpath选择节点, 非HTML/XML响应返回空结果
+func (res *Response) Xpath(path string) htmlquery.Selectors {
+	doc, err := res.Document()
+	if err != nil {
+		return htmlquery.Selectors{}
+	}
+	return doc.Xpath(path)
+}
+
+// TryCSS 通过CSS选择节点, 返回解析文档或选择器的错误
+func (res *Response) TryCSS(css string) (htmlquery.Selectors, error) {
+	doc, err := res.Document()
+	if err != nil {
+		return htmlquery.Selectors{}, err
+	}
+	return doc.TryCSS(css)
+}
+
+// TryXpath 通过Xpath选择节点, 返回解析文档或表达式的错误
+func (res *Response) TryXpath(path string) (htmlquery.Selectors, error) {
+	doc, err := res.Document()
+	if err != nil {
+		return htmlquery.Selectors{}, err
+	}
+	return doc.TryXpath(path)
+}
+
+// StructuredData 提取页面中的JSON-LD、Microdata、RDFa、OpenGraph和Twitter卡片数据
+func (res *Response) StructuredData() (*htmlquery.StructuredData, error) {
+	doc, err := res.Document()
+	if err != nil {
+		return nil, err
+	}
+	return doc.StructuredData(), nil
+}
+
+// JSON 将响应内容解码到v中
+func (res *Response) JSON(v interface{}) error {
+	if res.Type == BinaryContent {
+		return &ContentTypeError{URL: res.URL, Type: res.Type, Want: "json"}
+	}
+	return json.Unmarshal(res.Body, v)
+}
+
+// JSONDocument 返回解析后的JSON文档, 首次调用时解析
+func (res *Response) JSONDocument() (*jsonquery.Selector, error) {
+	res.jsonOnce.Do(func() {
+		if res.Type == BinaryContent {
+			res.jsonErr = &ContentTypeError{URL: res.URL, Type: res.Type, Want: "json"}
+		} else {
+			res.jsonDoc, res.jsonErr = jsonquery.NewSelector(res.Body)
+		}
+	})
+	return res.jsonDoc, res.jsonErr
+}
+
+// JSONPath 通过JSONPath选择值, 非JSON响应返回空结果
+func (res *Response) JSONPath(path string) jsonquery.Selectors {
+	doc, err := res.JSONDocument()
+	if err != nil {
+		return jsonquery.Selectors{}
+	}
+	return doc.JSONPath(path)
+}
+
 // WithStatus 设置响应状态
 func (res *Response) WithStatus(code int, sataus string) *Response {
 	res.StatusCode = code
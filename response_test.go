@@ -0,0 +1,83 @@
+package crawler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDetectContentType(t *testing.T) {
+	cases := []struct {
+		contentType string
+		body        string
+		want        ContentType
+	}{
+		{"text/html; charset=utf-8", "<html></html>", HTMLContent},
+		{"text/html", `{"a": 1}`, JSONContent},
+		{"application/json", `{"a": 1}`, JSONContent},
+		{"application/ld+json", `{"a": 1}`, JSONContent},
+		{"application/rss+xml", "<rss></rss>", XMLContent},
+		{"text/plain", "hello", TextContent},
+		{"image/png", "\x89PNG\r\n\x1a\n", BinaryContent},
+		{"", "<!DOCTYPE html><html></html>", HTMLContent},
+		{"", `[1, 2]`, JSONContent},
+		{"application/octet-stream", "%PDF-1.4", BinaryContent},
+	}
+	for _, c := range cases {
+		if got := detectContentType(c.contentType, []byte(c.body)); got != c.want {
+			t.Errorf("detectContentType(%q, %q) = %s, want %s", c.contentType, c.body, got, c.want)
+		}
+	}
+}
+
+func TestResponseLazyParse(t *testing.T) {
+	ctx := &Context{Settings: DefaultSettings()}
+	res := &Response{URL: "http://example.com/a.png", Body: []byte("\x89PNG\r\n\x1a\n"), Type: BinaryContent, context: ctx}
+	if len(res.CSS("a")) != 0 {
+		t.Error("CSS on binary response should be empty")
+	}
+	var typeErr *ContentTypeError
+	if _, err := res.Document(); !errors.As(err, &typeErr) {
+		t.Errorf("expected ContentTypeError, got %v", err)
+	}
+
+	res = &Response{Body: []byte(`<a href="/x">x</a>`), Type: HTMLContent, context: ctx}
+	if links := res.CSS("a"); len(links) != 1 || links[0].Attr("href") != "/x" {
+		t.Errorf("unexpected CSS result: %v", links)
+	}
+}
+
+func TestResponseSelector(t *testing.T) {
+	settings := DefaultSettings()
+	settings.AutoParseHtml = false
+	req := &Request{context: &Context{Settings: settings}}
+	res := (&Response{Body: []byte(`<html id="root"><a href="/x">x</a></html>`), Headers: http.Header{"Content-Type": {"text/html"}}}).WithRequest(req)
+	if res.Selector != nil {
+		t.Error("html should not be parsed eagerly when AutoParseHtml is false")
+	}
+	if links := res.CSS("a"); len(links) != 1 {
+		t.Errorf("lazy parse failed: %v", links)
+	}
+
+	settings.AutoParseHtml = true
+	res = (&Response{Body: []byte(`<a href="/x">x</a>`), Headers: http.Header{"Content-Type": {"text/html"}}}).WithRequest(req)
+	if res.Selector == nil || res.Node == nil {
+		t.Fatal("html should be parsed eagerly when AutoParseHtml is true")
+	}
+	if !strings.Contains(res.HTML(), `<a href="/x">x</a>`) || res.CSS("a").First().Attr("href") != "/x" {
+		t.Errorf("unexpected html %q", res.HTML())
+	}
+}
+
+func TestResponseDecodeError(t *testing.T) {
+	stats := NewStats()
+	req := &Request{Encoding: "no-such-charset", context: &Context{Settings: DefaultSettings(), Engine: &CrawlEngine{Stats: stats}}}
+	res := (&Response{Body: []byte(`<p>x</p>`), Headers: http.Header{"Content-Type": {"text/html"}}}).WithRequest(req)
+	if res.DecodeError == nil || string(res.Body) != `<p>x</p>` {
+		t.Errorf("DecodeError = %v, Body = %q", res.DecodeError, res.Body)
+	}
+	if stats.Get("response/decode_failed_count") != 1 {
+		t.Errorf("stats = %v", stats.Values())
+	}
+}
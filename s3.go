@@ -0,0 +1,195 @@
+package crawler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3FeedStorage S3兼容的对象存储, uri形如s3://bucket/key或s3://accessKey:secretKey@bucket/key;
+// 数据先写入临时文件, Close时上传
+type S3FeedStorage struct {
+	// Endpoint 服务地址, 如http://127.0.0.1:9000, 设置时使用path-style访问; 为空时读取AWS_ENDPOINT_URL, 仍为空则访问AWS
+	Endpoint string
+	// Region 区域, 为空时读取AWS_REGION, 默认us-east-1
+	Region string
+	// AccessKey和SecretKey为空时读取AWS_ACCESS_KEY_ID和AWS_SECRET_ACCESS_KEY
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// Client 上传使用的http.Client, 为空时使用http.DefaultClient
+	Client *http.Client
+}
+
+// Open 实现FeedStorage接口
+func (s *S3FeedStorage) Open(uri string) (io.WriteCloser, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return nil, fmt.Errorf("invalid s3 uri %q, want s3://bucket/key", redactURI(uri))
+	}
+	file, err := ioutil.TempFile("", "feed-*")
+	if err != nil {
+		return nil, err
+	}
+	return &s3Writer{File: file, storage: s.withDefaults(u), bucket: u.Host, key: strings.TrimPrefix(u.Path, "/")}, nil
+}
+
+// withDefaults 补全配置, uri中的账号优先
+func (s *S3FeedStorage) withDefaults(u *url.URL) *S3FeedStorage {
+	c := *s
+	if u.User != nil {
+		c.AccessKey = u.User.Username()
+		c.SecretKey, _ = u.User.Password()
+	}
+	if c.AccessKey == "" {
+		c.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		c.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		if c.SessionToken == "" {
+			c.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+		}
+	}
+	if c.Region == "" {
+		c.Region = os.Getenv("AWS_REGION")
+	}
+	if c.Region == "" {
+		c.Region = "us-east-1"
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if c.Client == nil {
+		c.Client = http.DefaultClient
+	}
+	return &c
+}
+
+// objectURL 设置Endpoint时为path-style, 否则为AWS virtual-hosted style
+func (s *S3FeedStorage) objectURL(bucket, key string) string {
+	path := "/" + s3EscapePath(key)
+	if s.Endpoint != "" {
+		return strings.TrimRight(s.Endpoint, "/") + "/" + bucket + path
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com%s", bucket, s.Region, path)
+}
+
+// Upload 上传对象
+func (s *S3FeedStorage) Upload(bucket, key string, body io.ReadSeeker) error {
+	hash := sha256.New()
+	size, err := io.Copy(hash, body)
+	if err != nil {
+		return err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, s.objectURL(bucket, key), ioutil.NopCloser(body))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	signS3Request(req, hex.EncodeToString(hash.Sum(nil)), s.AccessKey, s.SecretKey, s.Region, time.Now())
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("upload s3://%s/%s failed: %s %s", bucket, key, res.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+type s3Writer struct {
+	*os.File
+	storage     *S3FeedStorage
+	bucket, key string
+}
+
+// Close 上传并删除临时文件
+func (w *s3Writer) Close() error {
+	defer os.Remove(w.Name())
+	defer w.File.Close()
+	if _, err := w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return w.storage.Upload(w.bucket, w.key, w.File)
+}
+
+// signS3Request AWS Signature Version 4签名, 签名请求中已有的所有头及Host
+func signS3Request(req *http.Request, payloadHash, accessKey, secretKey, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3EscapePath 按SigV4的规则编码对象路径, 保留/
+func s3EscapePath(key string) string {
+	const unreserved = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_.~/"
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if strings.IndexByte(unreserved, c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -13,6 +13,14 @@ type Settings struct {
 	AutoParseHtml             bool
 	SkipTLSVerify             bool
 	Transport                 *http.Transport
+	Incremental               bool
+	IncrementalStorePath      string
+	// MaxResponseSize 响应最大字节数, 超过时中止下载, 0表示不限制
+	MaxResponseSize int64
+	// WarnResponseSize 响应超过该字节数时输出警告, 0表示不警告
+	WarnResponseSize int64
+	// Feeds 导出目标, 键为输出路径, item经过所有pipeline后写入
+	Feeds map[string]FeedOptions
 }
 
 // DefaultSettings 创建默认Setting
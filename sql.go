@@ -0,0 +1,518 @@
+package crawler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// SQLDialect SQLPipeline使用的数据库方言
+type SQLDialect interface {
+	// Placeholder 第n个参数的占位符, n从1开始
+	Placeholder(n int) string
+	// ColumnType Go类型对应的列类型, t为nil时表示类型未知
+	ColumnType(t reflect.Type) string
+	// TableColumns 表中已有的列, 表不存在时返回空
+	TableColumns(tx *sql.Tx, table string) (map[string]bool, error)
+}
+
+// SQLiteDialect SQLite方言, 可配合纯Go实现的modernc.org/sqlite驱动使用(驱动名sqlite)
+var SQLiteDialect SQLDialect = sqliteDialect{}
+
+// PostgresDialect Postgres方言, 可配合lib/pq或pgx的database/sql驱动使用
+var PostgresDialect SQLDialect = postgresDialect{}
+
+var (
+	timeType  = reflect.TypeOf(time.Time{})
+	bytesType = reflect.TypeOf([]byte(nil))
+)
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (sqliteDialect) ColumnType(t reflect.Type) string {
+	switch {
+	case t == nil:
+		return "TEXT"
+	case t == timeType:
+		return "DATETIME"
+	case t == bytesType:
+		return "BLOB"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "BOOLEAN"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "INTEGER"
+	case reflect.Float32, reflect.Float64:
+		return "REAL"
+	}
+	return "TEXT"
+}
+
+func (sqliteDialect) TableColumns(tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.Query("PRAGMA table_info(" + quoteIdent(table) + ")")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue interface{}
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+func (postgresDialect) ColumnType(t reflect.Type) string {
+	switch {
+	case t == nil:
+		return "TEXT"
+	case t == timeType:
+		return "TIMESTAMPTZ"
+	case t == bytesType:
+		return "BYTEA"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "BOOLEAN"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "BIGINT"
+	case reflect.Float32, reflect.Float64:
+		return "DOUBLE PRECISION"
+	case reflect.String:
+		return "TEXT"
+	}
+	return "JSONB"
+}
+
+func (postgresDialect) TableColumns(tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.Query("SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// sqlRow 一个item对应的行
+type sqlRow struct {
+	table   string
+	columns []string
+	values  []interface{}
+	types   []reflect.Type
+}
+
+// SQLPipeline 将map和结构体item写入关系数据库
+//
+// 表名为Table, 为空时使用item的TableName()方法, 否则使用结构体类型名的蛇形命名, map为items;
+// 结构体字段名依次取db标签、json标签和字段名的蛇形命名, 标签为"-"时忽略;
+// 表和列不存在时自动创建, 嵌套的结构体、map和slice以JSON保存
+type SQLPipeline struct {
+	DB      *sql.DB
+	Dialect SQLDialect
+	Table   string
+	// ConflictKeys 设置时按这些列upsert, 自动创建对应的唯一索引
+	ConflictKeys []string
+	// BatchSize 每批写入的item数, 每批在一个事务中写入, 默认100
+	BatchSize int
+	// MaxBufferSize 写入失败时缓存中最多保留的item数, 超出时丢弃最早的item, 默认为BatchSize的10倍
+	MaxBufferSize int
+
+	mutex   sync.Mutex
+	buffer  []*sqlRow
+	columns map[string]map[string]bool
+}
+
+// NewSQLPipeline 创建SQLPipeline
+func NewSQLPipeline(db *sql.DB, dialect SQLDialect) *SQLPipeline {
+	return &SQLPipeline{DB: db, Dialect: dialect}
+}
+
+// ProcessItem 实现ItemPipeline接口
+func (p *SQLPipeline) ProcessItem(item interface{}, ctx *Context) interface{} {
+	return processItemE(p, item, ctx)
+}
+
+// ProcessItemE 实现ItemPipelineE接口, 缓存item, 满一批时写入;
+// 写入失败不影响当前item, 只输出日志并计入sql/write_failed_count, 丢弃的item计入sql/discarded_count
+func (p *SQLPipeline) ProcessItemE(item interface{}, ctx *Context) (interface{}, error) {
+	row, err := p.toRow(item)
+	if err != nil {
+		return nil, fmt.Errorf("sql pipeline: %w", err)
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.buffer = append(p.buffer, row)
+	if len(p.buffer) >= p.batchSize() {
+		if discarded, err := p.flush(); err != nil {
+			log.Printf("sql pipeline: %v", err)
+			if ctx != nil && ctx.Engine != nil {
+				ctx.Engine.Stats.Inc("sql/write_failed_count")
+				ctx.Engine.Stats.Add("sql/discarded_count", int64(discarded))
+			}
+		}
+	}
+	return item, nil
+}
+
+// Flush 写入缓存中的item, 失败时item留在缓存中, 下次Flush时再写入;
+// 缓存超过MaxBufferSize时丢弃最早的item, 丢弃的数量计入返回的错误
+func (p *SQLPipeline) Flush() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	_, err := p.flush()
+	return err
+}
+
+// Close 写入缓存中的item, 不关闭DB
+func (p *SQLPipeline) Close() error {
+	return p.Flush()
+}
+
+func (p *SQLPipeline) batchSize() int {
+	if p.BatchSize > 0 {
+		return p.BatchSize
+	}
+	return 100
+}
+
+func (p *SQLPipeline) maxBufferSize() int {
+	if p.MaxBufferSize > 0 {
+		return p.MaxBufferSize
+	}
+	return p.batchSize() * 10
+}
+
+// flush 在一个事务中写入缓存的所有行, 失败时回滚, 这些行留在缓存中下次再写入, 返回丢弃的行数
+func (p *SQLPipeline) flush() (int, error) {
+	if len(p.buffer) == 0 {
+		return 0, nil
+	}
+	tx, err := p.DB.Begin()
+	if err == nil {
+		if err = p.write(tx, p.buffer); err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}
+	if err != nil {
+		// 回滚后已缓存的表结构可能不再准确
+		p.columns = nil
+		err = fmt.Errorf("write %d rows failed, kept for the next flush: %w", len(p.buffer), err)
+		discarded := len(p.buffer) - p.maxBufferSize()
+		if discarded > 0 {
+			p.buffer = append([]*sqlRow(nil), p.buffer[discarded:]...)
+			return discarded, fmt.Errorf("%w, discarded %d oldest rows", err, discarded)
+		}
+		return 0, err
+	}
+	p.buffer = nil
+	return 0, nil
+}
+
+func (p *SQLPipeline) write(tx *sql.Tx, rows []*sqlRow) error {
+	statements := make(map[string]*sql.Stmt)
+	defer func() {
+		for _, stmt := range statements {
+			stmt.Close()
+		}
+	}()
+	for _, row := range rows {
+		if err := p.ensureColumns(tx, row); err != nil {
+			return err
+		}
+		query := p.insertSQL(row.table, row.columns)
+		stmt, ok := statements[query]
+		if !ok {
+			var err error
+			if stmt, err = tx.Prepare(query); err != nil {
+				return err
+			}
+			statements[query] = stmt
+		}
+		if _, err := stmt.Exec(row.values...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureColumns 创建不存在的表和列
+func (p *SQLPipeline) ensureColumns(tx *sql.Tx, row *sqlRow) error {
+	if p.columns == nil {
+		p.columns = make(map[string]map[string]bool)
+	}
+	existing, ok := p.columns[row.table]
+	if !ok {
+		var err error
+		if existing, err = p.Dialect.TableColumns(tx, row.table); err != nil {
+			return err
+		}
+		if len(existing) == 0 {
+			if err := p.createTable(tx, row); err != nil {
+				return err
+			}
+			for _, column := range row.columns {
+				existing[column] = true
+			}
+		} else if len(p.ConflictKeys) > 0 {
+			if _, err := tx.Exec(p.uniqueIndexSQL(row.table)); err != nil {
+				return err
+			}
+		}
+		p.columns[row.table] = existing
+	}
+	for i, column := range row.columns {
+		if existing[column] {
+			continue
+		}
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", quoteIdent(row.table), quoteIdent(column), p.Dialect.ColumnType(row.types[i]))
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+		existing[column] = true
+	}
+	return nil
+}
+
+func (p *SQLPipeline) createTable(tx *sql.Tx, row *sqlRow) error {
+	definitions := make([]string, len(row.columns))
+	for i, column := range row.columns {
+		definitions[i] = quoteIdent(column) + " " + p.Dialect.ColumnType(row.types[i])
+	}
+	if len(p.ConflictKeys) > 0 {
+		definitions = append(definitions, "UNIQUE ("+quoteIdents(p.ConflictKeys)+")")
+	}
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", quoteIdent(row.table), strings.Join(definitions, ", "))
+	_, err := tx.Exec(query)
+	return err
+}
+
+func (p *SQLPipeline) uniqueIndexSQL(table string) string {
+	name := "uniq_" + table + "_" + strings.Join(p.ConflictKeys, "_")
+	return fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (%s)", quoteIdent(name), quoteIdent(table), quoteIdents(p.ConflictKeys))
+}
+
+// insertSQL INSERT语句, 设置ConflictKeys时冲突则更新其余列
+func (p *SQLPipeline) insertSQL(table string, columns []string) string {
+	placeholders := make([]string, len(columns))
+	for i := range columns {
+		placeholders[i] = p.Dialect.Placeholder(i + 1)
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", quoteIdent(table), quoteIdents(columns), strings.Join(placeholders, ", "))
+	if len(p.ConflictKeys) == 0 {
+		return query
+	}
+	keys := make(map[string]bool)
+	for _, key := range p.ConflictKeys {
+		keys[key] = true
+	}
+	var updates []string
+	for _, column := range columns {
+		if !keys[column] {
+			updates = append(updates, quoteIdent(column)+" = excluded."+quoteIdent(column))
+		}
+	}
+	if len(updates) == 0 {
+		return query + " ON CONFLICT (" + quoteIdents(p.ConflictKeys) + ") DO NOTHING"
+	}
+	return query + " ON CONFLICT (" + quoteIdents(p.ConflictKeys) + ") DO UPDATE SET " + strings.Join(updates, ", ")
+}
+
+func quoteIdents(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quoteIdent(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// tableNamer 自定义表名的item
+type tableNamer interface {
+	TableName() string
+}
+
+// toRow 将item转换为行, map的列按名称排序, 结构体的列按字段顺序
+func (p *SQLPipeline) toRow(item interface{}) (*sqlRow, error) {
+	row := &sqlRow{table: p.Table}
+	if namer, ok := item.(tableNamer); ok && row.table == "" {
+		row.table = namer.TableName()
+	}
+	v := reflect.ValueOf(item)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported item type %T", item)
+		}
+		if row.table == "" {
+			row.table = "items"
+		}
+		keys := make([]string, 0, v.Len())
+		for _, key := range v.MapKeys() {
+			keys = append(keys, key.String())
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := row.add(key, v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key()))); err != nil {
+				return nil, err
+			}
+		}
+	case reflect.Struct:
+		if row.table == "" {
+			row.table = snakeCase(v.Type().Name())
+		}
+		if err := addStructFields(row, v); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported item type %T", item)
+	}
+	if len(row.columns) == 0 {
+		return nil, fmt.Errorf("item %T has no columns", item)
+	}
+	return row, nil
+}
+
+// addStructFields 添加结构体的导出字段, 匿名结构体字段展开
+func addStructFields(row *sqlRow, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := fieldColumn(field)
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && name == "" {
+			if err := addStructFields(row, v.Field(i)); err != nil {
+				return err
+			}
+			continue
+		}
+		if name == "" {
+			name = snakeCase(field.Name)
+		}
+		if err := row.add(name, v.Field(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldColumn crawler标签、db标签或json标签中的列名
+func fieldColumn(field reflect.StructField) string {
+	if tag := field.Tag.Get("crawler"); tag != "" {
+		if tag == "-" {
+			return tag
+		}
+		if name := strings.Split(tag, ",")[0]; name != "" {
+			return name
+		}
+	}
+	for _, key := range []string{"db", "json"} {
+		if tag, ok := field.Tag.Lookup(key); ok {
+			return strings.Split(tag, ",")[0]
+		}
+	}
+	return ""
+}
+
+// add 添加一列, 嵌套的结构体、map和slice编码为JSON, nil指针、map和slice写入NULL
+func (row *sqlRow) add(column string, v reflect.Value) error {
+	for v.IsValid() && (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) && !v.IsNil() {
+		v = v.Elem()
+	}
+	var value interface{}
+	var t reflect.Type
+	if v.IsValid() {
+		t = v.Type()
+		switch kind := t.Kind(); {
+		case kind == reflect.Chan || kind == reflect.Func || kind == reflect.Complex64 ||
+			kind == reflect.Complex128 || kind == reflect.UnsafePointer:
+			return fmt.Errorf("unsupported type %s of column %s", t, column)
+		case kind == reflect.Interface:
+			t = nil
+		case kind == reflect.Ptr:
+			t = t.Elem()
+		case (kind == reflect.Map || kind == reflect.Slice) && v.IsNil():
+		case t == timeType || t == bytesType:
+			value = v.Interface()
+		case kind == reflect.Struct || kind == reflect.Map || kind == reflect.Slice || kind == reflect.Array:
+			data, err := json.Marshal(v.Interface())
+			if err != nil {
+				return fmt.Errorf("encode column %s: %w", column, err)
+			}
+			value = string(data)
+		case kind == reflect.Uint64 || kind == reflect.Uint || kind == reflect.Uintptr:
+			if v.Uint() > math.MaxInt64 {
+				return fmt.Errorf("value %d of column %s overflows int64", v.Uint(), column)
+			}
+			value = int64(v.Uint())
+		default:
+			value = v.Interface()
+		}
+	}
+	row.columns = append(row.columns, column)
+	row.values = append(row.values, value)
+	row.types = append(row.types, t)
+	return nil
+}
+
+// snakeCase 驼峰命名转为蛇形命名, 如ProductURL转为product_url
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
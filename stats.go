@@ -0,0 +1,63 @@
+package crawler
+
+import "sync"
+
+// Stats 爬取过程中的计数, 并发安全, nil时所有操作无效
+type Stats struct {
+	mutex  sync.Mutex
+	values map[string]int64
+}
+
+// NewStats 创建Stats
+func NewStats() *Stats {
+	return &Stats{values: make(map[string]int64)}
+}
+
+// Inc 计数加1
+func (s *Stats) Inc(key string) {
+	s.Add(key, 1)
+}
+
+// Add 计数加n
+func (s *Stats) Add(key string, n int64) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.values[key] += n
+}
+
+// Set 设置计数
+func (s *Stats) Set(key string, value int64) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.values[key] = value
+}
+
+// Get 获取计数, 不存在时返回0
+func (s *Stats) Get(key string) int64 {
+	if s == nil {
+		return 0
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.values[key]
+}
+
+// Values 所有计数的副本
+func (s *Stats) Values() map[string]int64 {
+	values := make(map[string]int64)
+	if s == nil {
+		return values
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for k, v := range s.values {
+		values[k] = v
+	}
+	return values
+}
@@ -0,0 +1,560 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// FieldError 一个字段未通过的校验规则
+type FieldError struct {
+	// Field 字段路径, 嵌套字段以.分隔, 数组元素为下标
+	Field string
+	// Rule 未通过的规则, 如required、type、pattern、minimum
+	Rule    string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationErrors item的校验错误; 结构体item包含该类型的字段时, 标记模式下会写入该字段
+type ValidationErrors []*FieldError
+
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, e := range errs {
+		messages[i] = e.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Strings 每个错误的描述
+func (errs ValidationErrors) Strings() []string {
+	messages := make([]string, len(errs))
+	for i, e := range errs {
+		messages[i] = e.Error()
+	}
+	return messages
+}
+
+func (errs *ValidationErrors) add(field, rule, format string, args ...interface{}) {
+	*errs = append(*errs, &FieldError{Field: field, Rule: rule, Message: fmt.Sprintf(format, args...)})
+}
+
+// JSONSchema JSON Schema的子集: type、properties、required、items、enum、pattern、format,
+// minLength、maxLength、minimum、maximum、exclusiveMinimum、exclusiveMaximum、minItems和maxItems.
+// 与JSON Schema不同, required的属性值为null或空字符串时也视为缺失
+type JSONSchema struct {
+	// Type 类型名或类型名列表: object、array、string、number、integer、boolean、null
+	Type             interface{}            `json:"type,omitempty"`
+	Properties       map[string]*JSONSchema `json:"properties,omitempty"`
+	Required         []string               `json:"required,omitempty"`
+	Items            *JSONSchema            `json:"items,omitempty"`
+	Enum             []interface{}          `json:"enum,omitempty"`
+	Pattern          string                 `json:"pattern,omitempty"`
+	Format           string                 `json:"format,omitempty"`
+	MinLength        *int                   `json:"minLength,omitempty"`
+	MaxLength        *int                   `json:"maxLength,omitempty"`
+	Minimum          *float64               `json:"minimum,omitempty"`
+	Maximum          *float64               `json:"maximum,omitempty"`
+	ExclusiveMinimum *float64               `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum *float64               `json:"exclusiveMaximum,omitempty"`
+	MinItems         *int                   `json:"minItems,omitempty"`
+	MaxItems         *int                   `json:"maxItems,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// ParseJSONSchema 解析JSON Schema, 检查类型名和正则表达式
+func ParseJSONSchema(data []byte) (*JSONSchema, error) {
+	schema := &JSONSchema{}
+	if err := json.Unmarshal(data, schema); err != nil {
+		return nil, err
+	}
+	if err := schema.compile(""); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// MustParseJSONSchema 与ParseJSONSchema相同, 出错时panic
+func MustParseJSONSchema(data string) *JSONSchema {
+	schema, err := ParseJSONSchema([]byte(data))
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+func (s *JSONSchema) compile(path string) error {
+	for _, t := range s.types() {
+		switch t {
+		case "object", "array", "string", "number", "integer", "boolean", "null":
+		default:
+			return fmt.Errorf("schema %s: unknown type %q", schemaPath(path), t)
+		}
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("schema %s: %v", schemaPath(path), err)
+		}
+		s.pattern = re
+	}
+	for name, property := range s.Properties {
+		if err := property.compile(joinField(path, name)); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile(path + "[]")
+	}
+	return nil
+}
+
+func schemaPath(path string) string {
+	if path == "" {
+		return "root"
+	}
+	return path
+}
+
+func (s *JSONSchema) types() []string {
+	switch t := s.Type.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		types := make([]string, 0, len(t))
+		for _, v := range t {
+			types = append(types, fmt.Sprint(v))
+		}
+		return types
+	}
+	return nil
+}
+
+// Validate 校验item, item先按导出时的规则编码为JSON
+func (s *JSONSchema) Validate(item interface{}) ValidationErrors {
+	data, err := marshalItem(item)
+	if err != nil {
+		return ValidationErrors{{Rule: "type", Message: err.Error()}}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return ValidationErrors{{Rule: "type", Message: err.Error()}}
+	}
+	var errs ValidationErrors
+	s.validate("", value, &errs)
+	return errs
+}
+
+func (s *JSONSchema) validate(path string, value interface{}, errs *ValidationErrors) {
+	if types := s.types(); len(types) > 0 {
+		matched := false
+		for _, t := range types {
+			if jsonTypeMatches(t, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			errs.add(path, "type", "want %s, got %s", strings.Join(types, " or "), jsonTypeName(value))
+			return
+		}
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if jsonEqual(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs.add(path, "enum", "%v is not one of %v", value, s.Enum)
+		}
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		missing := map[string]bool{}
+		for _, name := range s.Required {
+			if property, ok := v[name]; !ok || property == nil || property == "" {
+				missing[name] = true
+				errs.add(joinField(path, name), "required", "is required")
+			}
+		}
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, ok := v[name]; ok && property != nil && !missing[name] {
+				s.Properties[name].validate(joinField(path, name), property, errs)
+			}
+		}
+	case []interface{}:
+		checkLength(path, "Items", len(v), s.MinItems, s.MaxItems, errs)
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(path+"["+strconv.Itoa(i)+"]", item, errs)
+			}
+		}
+	case string:
+		checkLength(path, "Length", utf8.RuneCountInString(v), s.MinLength, s.MaxLength, errs)
+		if s.Pattern != "" {
+			re := s.pattern
+			if re == nil {
+				var err error
+				if re, err = getValidatePattern(s.Pattern); err != nil {
+					errs.add(path, "pattern", "invalid pattern %s", s.Pattern)
+				}
+			}
+			if re != nil && !re.MatchString(v) {
+				errs.add(path, "pattern", "%q does not match %s", v, s.Pattern)
+			}
+		}
+		if s.Format != "" && !formatMatches(s.Format, v) {
+			errs.add(path, "format", "%q is not a valid %s", v, s.Format)
+		}
+	case json.Number:
+		f, _ := v.Float64()
+		if s.Minimum != nil && f < *s.Minimum {
+			errs.add(path, "minimum", "%v is less than %v", v, *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			errs.add(path, "maximum", "%v is greater than %v", v, *s.Maximum)
+		}
+		if s.ExclusiveMinimum != nil && f <= *s.ExclusiveMinimum {
+			errs.add(path, "exclusiveMinimum", "%v is not greater than %v", v, *s.ExclusiveMinimum)
+		}
+		if s.ExclusiveMaximum != nil && f >= *s.ExclusiveMaximum {
+			errs.add(path, "exclusiveMaximum", "%v is not less than %v", v, *s.ExclusiveMaximum)
+		}
+	}
+}
+
+func joinField(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// checkLength 检查字符串长度或数组元素数, kind为Length或Items
+func checkLength(path, kind string, n int, min, max *int, errs *ValidationErrors) {
+	if min != nil && n < *min {
+		errs.add(path, "min"+kind, "length %d is less than %d", n, *min)
+	}
+	if max != nil && n > *max {
+		errs.add(path, "max"+kind, "length %d is greater than %d", n, *max)
+	}
+}
+
+func jsonTypeMatches(t string, value interface{}) bool {
+	switch v := value.(type) {
+	case json.Number:
+		if t == "integer" {
+			f, err := v.Float64()
+			return err == nil && f == math.Trunc(f)
+		}
+		return t == "number"
+	default:
+		return jsonTypeName(value) == t
+	}
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number, float64:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func jsonEqual(a, b interface{}) bool {
+	if n, ok := b.(json.Number); ok {
+		f, _ := n.Float64()
+		switch a := a.(type) {
+		case float64:
+			return a == f
+		case json.Number:
+			af, _ := a.Float64()
+			return af == f
+		}
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+var (
+	emailPattern    = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	uriPattern      = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://\S+$`)
+	datePattern     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	dateTimePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}[Tt ]\d{2}:\d{2}:\d{2}(\.\d+)?([Zz]|[+-]\d{2}:\d{2})$`)
+)
+
+// formatMatches 支持email、uri、date和date-time格式, 其他格式不检查
+func formatMatches(format, value string) bool {
+	switch format {
+	case "email":
+		return emailPattern.MatchString(value)
+	case "uri", "url":
+		return uriPattern.MatchString(value)
+	case "date":
+		return datePattern.MatchString(value)
+	case "date-time":
+		return dateTimePattern.MatchString(value)
+	}
+	return true
+}
+
+// ValidateStruct 按validate标签校验结构体item, 规则以逗号分隔:
+// required 不能为空值; min=N、max=N 数字的范围或字符串、切片的长度; oneof=a b c 取值之一;
+// pattern=正则 字符串须匹配, 须放在最后. 除required外的规则在字段为空值时不检查,
+// 空值指空白字符串、空切片和map、nil指针, 数字0和false不是空值
+func ValidateStruct(item interface{}) ValidationErrors {
+	schema, err := SchemaOf(item)
+	if err != nil {
+		return ValidationErrors{&FieldError{Rule: "crawler", Message: err.Error()}}
+	}
+	if schema == nil {
+		return nil
+	}
+	values := schema.Values(item)
+	if values == nil {
+		return nil
+	}
+	var errs ValidationErrors
+	for i, f := range schema.Fields {
+		tag := f.Tag.Get("validate")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		validateField(f.Name, values[i], tag, &errs)
+	}
+	return errs
+}
+
+func validateField(name string, v reflect.Value, tag string, errs *ValidationErrors) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			break
+		}
+		v = v.Elem()
+	}
+	empty := isEmptyValue(v)
+	for tag != "" {
+		rule := tag
+		if strings.HasPrefix(tag, "pattern=") {
+			tag = ""
+		} else if i := strings.Index(tag, ","); i >= 0 {
+			rule, tag = tag[:i], tag[i+1:]
+		} else {
+			tag = ""
+		}
+		key, arg := strings.TrimSpace(rule), ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			key, arg = strings.TrimSpace(rule[:i]), rule[i+1:]
+		}
+		if key == "required" {
+			if empty {
+				errs.add(name, "required", "is required")
+			}
+			continue
+		}
+		if empty || key == "" {
+			continue
+		}
+		switch key {
+		case "min", "max":
+			limit, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				errs.add(name, key, "invalid rule %s", rule)
+				continue
+			}
+			n, isLength := fieldMeasure(v)
+			if (key == "min" && n < limit) || (key == "max" && n > limit) {
+				if isLength {
+					errs.add(name, key, "length %v is out of range %s", n, rule)
+				} else {
+					errs.add(name, key, "%v is out of range %s", n, rule)
+				}
+			}
+		case "oneof":
+			text := fmt.Sprint(v.Interface())
+			found := false
+			for _, option := range strings.Fields(arg) {
+				if option == text {
+					found = true
+					break
+				}
+			}
+			if !found {
+				errs.add(name, key, "%q is not one of %s", text, arg)
+			}
+		case "pattern":
+			re, err := getValidatePattern(arg)
+			if err != nil {
+				errs.add(name, key, "invalid pattern %s", arg)
+				continue
+			}
+			if v.Kind() == reflect.String && !re.MatchString(v.String()) {
+				errs.add(name, key, "%q does not match %s", v.String(), arg)
+			}
+		default:
+			errs.add(name, key, "unknown rule %s", key)
+		}
+	}
+}
+
+// fieldMeasure 数字字段返回其值, 字符串返回字符数, 切片和map返回长度
+func fieldMeasure(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), false
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len()), true
+	}
+	return 0, false
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(v.String()) == ""
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+var (
+	validatePatterns      = map[string]*regexp.Regexp{}
+	validatePatternsMutex sync.Mutex
+)
+
+func getValidatePattern(pattern string) (*regexp.Regexp, error) {
+	validatePatternsMutex.Lock()
+	defer validatePatternsMutex.Unlock()
+	if re, ok := validatePatterns[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	validatePatterns[pattern] = re
+	return re, nil
+}
+
+// fieldIndexPattern 字段路径中的数组下标
+var fieldIndexPattern = regexp.MustCompile(`\[\d+\]`)
+
+// ValidationPipeline 校验item的pipeline, 设置Schema时按JSON Schema校验, 否则按结构体的validate标签校验.
+// 未通过的item默认丢弃, Flag为true时保留并标记: map[string]interface{}写入FlagField, 结构体写入ValidationErrors类型的字段.
+// 统计validation/invalid_count和每个字段每条规则的validation/field_failed_count/<字段>/<规则>,
+// 字段中的数组下标统一为[], 如tags[]
+type ValidationPipeline struct {
+	Schema *JSONSchema
+	// Flag 为true时只标记不丢弃
+	Flag bool
+	// FlagField 标记map item时写入错误列表的键, 默认为_validation_errors
+	FlagField string
+}
+
+// NewValidationPipeline 创建ValidationPipeline, schema为空时按validate标签校验
+func NewValidationPipeline(schema *JSONSchema) *ValidationPipeline {
+	return &ValidationPipeline{Schema: schema}
+}
+
+// Validate 校验item, 通过时返回nil
+func (p *ValidationPipeline) Validate(item interface{}) ValidationErrors {
+	if p.Schema != nil {
+		return p.Schema.Validate(item)
+	}
+	return ValidateStruct(item)
+}
+
+// ProcessItem 实现ItemPipeline接口
+func (p *ValidationPipeline) ProcessItem(item interface{}, ctx *Context) interface{} {
+	return processItemE(p, item, ctx)
+}
+
+// ProcessItemE 实现ItemPipelineE接口
+func (p *ValidationPipeline) ProcessItemE(item interface{}, ctx *Context) (interface{}, error) {
+	errs := p.Validate(item)
+	if len(errs) == 0 {
+		return item, nil
+	}
+	var stats *Stats
+	if ctx != nil && ctx.Engine != nil {
+		stats = ctx.Engine.Stats
+	}
+	stats.Inc("validation/invalid_count")
+	for _, e := range errs {
+		stats.Inc("validation/field_failed_count/" + fieldIndexPattern.ReplaceAllString(e.Field, "[]") + "/" + e.Rule)
+	}
+	if !p.Flag {
+		return nil, &DroppedItemError{Reason: "validation failed", Err: errs}
+	}
+	p.flag(item, errs)
+	return item, nil
+}
+
+// flag 将错误写入item
+func (p *ValidationPipeline) flag(item interface{}, errs ValidationErrors) {
+	if m, ok := item.(map[string]interface{}); ok {
+		field := p.FlagField
+		if field == "" {
+			field = "_validation_errors"
+		}
+		m[field] = errs.Strings()
+		return
+	}
+	v := reflect.ValueOf(item)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return
+	}
+	v = v.Elem()
+	errsType := reflect.TypeOf(errs)
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Type == errsType && v.Field(i).CanSet() {
+			v.Field(i).Set(reflect.ValueOf(errs))
+			return
+		}
+	}
+}
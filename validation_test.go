@@ -0,0 +1,108 @@
+package crawler
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type validatedBook struct {
+	Title  string           `json:"title" validate:"required,max=20"`
+	Price  float64          `json:"price" validate:"min=0.01,max=1000"`
+	ISBN   string           `json:"isbn" validate:"pattern=^[0-9]{3}-[0-9]{10}$"`
+	Format string           `json:"format" validate:"oneof=paper ebook"`
+	Tags   []string         `json:"tags" validate:"max=2"`
+	Errors ValidationErrors `json:"-"`
+}
+
+func TestValidateStruct(t *testing.T) {
+	if errs := ValidateStruct(&validatedBook{Title: "Go", Price: 10, ISBN: "978-0134190440", Format: "ebook"}); len(errs) != 0 {
+		t.Errorf("valid item: %v", errs)
+	}
+	// 空值的字段只检查required, 数字0不是空值
+	if errs := ValidateStruct(validatedBook{Title: "Go"}); len(errs) != 1 || errs[0].Field != "price" || errs[0].Rule != "min" {
+		t.Errorf("empty optional fields: %v", errs)
+	}
+	errs := ValidateStruct(&validatedBook{Title: " ", Price: 2000, ISBN: "x,y", Format: "audio", Tags: []string{"a", "b", "c"}})
+	var rules []string
+	for _, e := range errs {
+		rules = append(rules, e.Field+"/"+e.Rule)
+	}
+	if strings.Join(rules, " ") != "title/required price/max isbn/pattern format/oneof tags/max" {
+		t.Errorf("rules = %v", rules)
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	if _, err := ParseJSONSchema([]byte(`{"type": "text"}`)); err == nil {
+		t.Error("unknown types should be rejected")
+	}
+	if _, err := ParseJSONSchema([]byte(`{"properties": {"a": {"pattern": "("}}}`)); err == nil {
+		t.Error("invalid patterns should be rejected")
+	}
+	schema := MustParseJSONSchema(`{
+		"type": "object",
+		"required": ["title", "url"],
+		"properties": {
+			"title": {"type": "string", "minLength": 2},
+			"url": {"type": "string", "format": "uri"},
+			"price": {"type": "number", "minimum": 0, "exclusiveMinimum": 0},
+			"stock": {"type": ["integer", "null"]},
+			"tags": {"type": "array", "maxItems": 2, "items": {"type": "string", "enum": ["go", "book"]}}
+		}
+	}`)
+	if errs := schema.Validate(map[string]interface{}{"title": "Go", "url": "http://a/b", "price": 1.5, "stock": nil, "tags": []string{"go"}}); len(errs) != 0 {
+		t.Errorf("valid item: %v", errs)
+	}
+	errs := schema.Validate(map[string]interface{}{"title": "G", "url": "/b", "price": -1, "stock": 1.5, "tags": []interface{}{"go", 1, "x"}})
+	var rules []string
+	for _, e := range errs {
+		rules = append(rules, e.Field+"/"+e.Rule)
+	}
+	want := "price/minimum price/exclusiveMinimum stock/type tags/maxItems tags[1]/type tags[2]/enum title/minLength url/format"
+	if strings.Join(rules, " ") != want {
+		t.Errorf("rules = %v", rules)
+	}
+	if errs := schema.Validate(map[string]interface{}{"title": ""}); len(errs) != 2 || errs[0].Rule != "required" {
+		t.Errorf("required: %v", errs)
+	}
+}
+
+func TestValidationPipeline(t *testing.T) {
+	stats := NewStats()
+	ctx := &Context{Engine: &CrawlEngine{Stats: stats}}
+
+	pipeline := NewValidationPipeline(nil)
+	_, err := pipeline.ProcessItemE(&validatedBook{Price: -1}, ctx)
+	var dropped *DroppedItemError
+	var errs ValidationErrors
+	if !errors.As(err, &dropped) || !errors.As(err, &errs) || len(errs) != 2 {
+		t.Errorf("err = %v", err)
+	}
+	if item, err := pipeline.ProcessItemE(&validatedBook{Title: "Go", Price: 1}, ctx); err != nil || item == nil {
+		t.Errorf("valid item = %v, %v", item, err)
+	}
+
+	pipeline = &ValidationPipeline{Flag: true}
+	book := &validatedBook{Price: 1}
+	if item, err := pipeline.ProcessItemE(book, ctx); err != nil || item != book || len(book.Errors) != 1 {
+		t.Errorf("flagged item = %+v, %v", item, err)
+	}
+	pipeline.Schema = MustParseJSONSchema(`{"required": ["title"]}`)
+	m := map[string]interface{}{"price": 1}
+	pipeline.ProcessItemE(m, ctx)
+	if flags, _ := m["_validation_errors"].([]string); len(flags) != 1 || flags[0] != "title: is required" {
+		t.Errorf("flagged map = %v", m)
+	}
+
+	if stats.Get("validation/invalid_count") != 3 || stats.Get("validation/field_failed_count/title/required") != 3 ||
+		stats.Get("validation/field_failed_count/price/min") != 1 {
+		t.Errorf("stats = %v", stats.Values())
+	}
+
+	pipeline.Schema = MustParseJSONSchema(`{"properties": {"tags": {"items": {"type": "string"}}}}`)
+	pipeline.ProcessItemE(map[string]interface{}{"tags": []interface{}{1, "a", 2}}, ctx)
+	if stats.Get("validation/field_failed_count/tags[]/type") != 2 {
+		t.Errorf("array index should be normalized: %v", stats.Values())
+	}
+}